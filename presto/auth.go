@@ -0,0 +1,172 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Authentication mechanisms, in the order a connection with several of them
+// falls back on.
+const (
+	AuthBasic    = "basic"    // HTTP basic authentication with the user and password of the DSN
+	AuthBearer   = "bearer"   // Bearer tokens of the TokenProvider, or else the AccessToken of the DSN
+	AuthKerberos = "kerberos" // SPNEGO with the Kerberos keytab of the DSN
+)
+
+// AuthFailure is the failure of an authentication mechanism.
+type AuthFailure struct {
+	Mechanism  string // One of AuthBasic, AuthBearer and AuthKerberos
+	StatusCode int    // Status of the response rejecting the credentials, zero if they couldn't be obtained
+	Err        error
+}
+
+// ErrAuthentication indicates that presto rejected every authentication
+// mechanism of a connection with several of them, or that their credentials
+// couldn't be obtained. Failures holds the failure of each mechanism, in the
+// order they were tried.
+type ErrAuthentication struct {
+	Failures []AuthFailure
+}
+
+// Error implements the error interface.
+func (e *ErrAuthentication) Error() string {
+	msgs := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		msgs[i] = fmt.Sprintf("%s: %v", f.Mechanism, f.Err)
+	}
+	return "presto: authentication failed with every mechanism: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the failures of the mechanisms.
+func (e *ErrAuthentication) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// authMechanisms returns the authentication mechanisms configured for the
+// connection, in their order of precedence.
+func (c *Conn) authMechanisms() []string {
+	var ms []string
+	if c.auth != nil {
+		ms = append(ms, AuthBasic)
+	}
+	if c.tokenProvider != nil || c.accessToken != "" {
+		ms = append(ms, AuthBearer)
+	}
+	if c.kerberosEnabled {
+		ms = append(ms, AuthKerberos)
+	}
+	return ms
+}
+
+// authState is the index of the authentication mechanism a query uses among
+// those of its connection. Every query starts from the first mechanism, and
+// keeps the one that worked for its subsequent requests.
+type authState struct {
+	index int32
+}
+
+// withAuthState returns a copy of ctx with a new authentication state,
+// unless it already has one.
+func withAuthState(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(authStateKey).(*authState); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, authStateKey, &authState{})
+}
+
+// authIndex returns the index of the mechanism of the query of ctx, which is
+// the first one for requests outside of queries.
+func authIndex(ctx context.Context) *int32 {
+	if s, ok := ctx.Value(authStateKey).(*authState); ok {
+		return &s.index
+	}
+	return new(int32)
+}
+
+// authMechanism returns the mechanism the query of ctx uses, empty if none.
+func (c *Conn) authMechanism(ctx context.Context) string {
+	ms := c.authMechanisms()
+	if len(ms) == 0 {
+		return ""
+	}
+	return ms[int(atomic.LoadInt32(authIndex(ctx)))%len(ms)]
+}
+
+// authorize sets the Authorization header of a request to presto for the
+// query of ctx with the authentication mechanism the query uses. The
+// tokens of the token provider replace the static access token of the DSN.
+// Requests to the storage of spooled segments are not authorized with it.
+func (c *Conn) authorize(ctx context.Context, req *http.Request) error {
+	switch c.authMechanism(ctx) {
+	case AuthBasic:
+		pass, _ := c.auth.Password()
+		req.SetBasicAuth(c.auth.Username(), pass)
+	case AuthBearer:
+		token := c.accessToken
+		if c.tokenProvider != nil {
+			var err error
+			if token, err = c.tokenProvider.Token(ctx); err != nil {
+				return fmt.Errorf("presto: getting access token: %w", err)
+			}
+			if token == "" {
+				return fmt.Errorf("presto: getting access token: empty token")
+			}
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case AuthKerberos:
+		if err := c.kerberosClient.SetSPNEGOHeader(req, "presto/"+req.URL.Hostname()); err != nil {
+			return fmt.Errorf("error setting client SPNEGO header: %v", err)
+		}
+	}
+	return nil
+}
+
+// authFallback records the failure of the mechanism the query of ctx uses
+// to authenticate a request, and switches the query to the next one. It
+// reports whether the request should be retried with it, which is until
+// every mechanism failed.
+func (c *Conn) authFallback(ctx context.Context, failures *[]AuthFailure, status int, err error) bool {
+	ms := c.authMechanisms()
+	if len(ms) == 0 {
+		return false
+	}
+	index := authIndex(ctx)
+	i := int(atomic.LoadInt32(index)) % len(ms)
+	*failures = append(*failures, AuthFailure{Mechanism: ms[i], StatusCode: status, Err: err})
+	if len(*failures) >= len(ms) {
+		return false
+	}
+	atomic.StoreInt32(index, int32((i+1)%len(ms)))
+	return true
+}
+
+// authError returns the error of a request whose authentication failed
+// with err, aggregating the failures of every mechanism if there were
+// several.
+func authError(failures []AuthFailure, err *ErrQueryFailed) error {
+	if len(failures) < 2 {
+		return err
+	}
+	return &ErrQueryFailed{StatusCode: err.StatusCode, Reason: &ErrAuthentication{Failures: failures}}
+}
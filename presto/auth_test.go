@@ -0,0 +1,143 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newAuthServer returns a server accepting only the Authorization header
+// accepted, and recording the Authorization header of every request.
+func newAuthServer(accepted string, auths *[]string) *httptest.Server {
+	ts := newPagedServer(queryResponse{})
+	ts.Config.Handler = func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*auths = append(*auths, r.Header.Get("Authorization"))
+			if r.Header.Get("Authorization") != accepted {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}(ts.Config.Handler)
+	return ts
+}
+
+func TestAuthFallback(t *testing.T) {
+	var auths []string
+	ts := newAuthServer("Bearer test_token", &auths)
+	defer ts.Close()
+	dsn := strings.Replace(ts.URL, "http://", "http://foobar:secret@", 1) + "?insecure_basic_auth=true&AccessToken=test_token"
+	db, err := sql.Open("presto", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	for i := 0; i < 2; i++ {
+		auths = nil
+		rows, err := db.Query("SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+		}
+		rows.Close()
+		// Every query starts with basic auth, and keeps the bearer token
+		// that worked to fetch its results.
+		if len(auths) < 3 || !strings.HasPrefix(auths[0], "Basic ") {
+			t.Fatalf("query %d: want basic auth first, got %q", i, auths)
+		}
+		for _, a := range auths[1:] {
+			if a != "Bearer test_token" {
+				t.Fatalf("query %d: want bearer token after fallback, got %q", i, auths)
+			}
+		}
+	}
+}
+
+func TestAuthFallbackFailures(t *testing.T) {
+	var auths []string
+	ts := newAuthServer("Bearer other_token", &auths)
+	defer ts.Close()
+	c, err := NewConnector(&Config{
+		PrestoURI:         strings.Replace(ts.URL, "http://", "http://foobar@", 1),
+		Password:          "secret",
+		InsecureBasicAuth: true,
+		TokenProvider: TokenProviderFunc(func(ctx context.Context) (string, error) {
+			return "test_token", nil
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	_, err = db.Query("SELECT 1")
+	var authErr *ErrAuthentication
+	if !errors.As(err, &authErr) {
+		t.Fatal("want ErrAuthentication, got", err)
+	}
+	if len(authErr.Failures) != 2 ||
+		authErr.Failures[0].Mechanism != AuthBasic ||
+		authErr.Failures[1].Mechanism != AuthBearer {
+		t.Fatalf("unexpected failures: %+v", authErr.Failures)
+	}
+	for _, f := range authErr.Failures {
+		if f.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: want status 401, got %d", f.Mechanism, f.StatusCode)
+		}
+	}
+	var qf *ErrQueryFailed
+	if !errors.As(err, &qf) || qf.StatusCode != http.StatusUnauthorized {
+		t.Fatal("want ErrQueryFailed with status 401, got", err)
+	}
+	if !strings.Contains(err.Error(), "basic: ") || !strings.Contains(err.Error(), "bearer: ") {
+		t.Fatal("want the failure of every mechanism in the error, got", err)
+	}
+}
+
+func TestAuthFallbackTokenError(t *testing.T) {
+	var auths []string
+	ts := newAuthServer("Basic Zm9vYmFyOnNlY3JldA==", &auths)
+	defer ts.Close()
+	errToken := errors.New("token expired")
+	c, err := NewConnector(&Config{
+		PrestoURI: ts.URL,
+		TokenProvider: TokenProviderFunc(func(ctx context.Context) (string, error) {
+			return "", errToken
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(c)
+	defer db.Close()
+
+	// With a single mechanism, its error is returned as is.
+	_, err = db.Query("SELECT 1")
+	var authErr *ErrAuthentication
+	if errors.As(err, &authErr) || !errors.Is(err, errToken) {
+		t.Fatal("want the token error, got", err)
+	}
+}
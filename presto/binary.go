@@ -0,0 +1,79 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"database/sql/driver"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// BinaryReader reads the bytes of a varbinary value of a query run with
+// WithBinaryStreams. The value is decoded from its base64 encoding in the
+// presto protocol as it is read, in chunks of the size of the reads, so it
+// is not copied decoded into a []byte. The encoded value is still held in
+// memory as a whole, as its page was decoded. Unlike the values of
+// WithRawBytes, it remains valid after the next call to Next.
+type BinaryReader struct {
+	enc string
+	r   io.Reader
+}
+
+func newBinaryReader(enc string) *BinaryReader {
+	return &BinaryReader{
+		enc: enc,
+		r:   base64.NewDecoder(base64.StdEncoding, strings.NewReader(enc)),
+	}
+}
+
+// Read implements the io.Reader interface.
+func (b *BinaryReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("presto: decoding varbinary: %w", err)
+	}
+	return n, err
+}
+
+// Len returns the size of the whole value in bytes, regardless of the bytes
+// already read.
+func (b *BinaryReader) Len() int {
+	n := len(b.enc) / 4 * 3
+	switch {
+	case strings.HasSuffix(b.enc, "=="):
+		n -= 2
+	case strings.HasSuffix(b.enc, "="):
+		n--
+	}
+	return n
+}
+
+// binaryStreamConverter delivers varbinary values as BinaryReaders, for
+// queries run with WithBinaryStreams.
+type binaryStreamConverter struct{}
+
+// ConvertValue implements driver.ValueConverter interface.
+func (binaryStreamConverter) ConvertValue(v any) (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("presto: binary stream converter needs string and received %T", v)
+	}
+	return newBinaryReader(s), nil
+}
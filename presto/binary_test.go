@@ -0,0 +1,92 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestWithBinaryStreams(t *testing.T) {
+	blob := bytes.Repeat([]byte("presto\x00\xff"), 10000)
+	columns := []queryColumn{
+		{Name: "b", Type: "varbinary", TypeSignature: typeSignature{RawType: "varbinary"}},
+	}
+	ts := newPagedServer(queryResponse{Columns: columns, Data: []queryData{
+		{base64.StdEncoding.EncodeToString(blob)},
+		{nil},
+		{base64.StdEncoding.EncodeToString([]byte("ab"))},
+	}})
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.QueryContext(WithBinaryStreams(context.Background()), "SELECT b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := types[0].ScanType(); got != reflect.TypeOf((*BinaryReader)(nil)) {
+		t.Fatal("unexpected scan type:", got)
+	}
+	var readers []*BinaryReader
+	for rows.Next() {
+		var r *BinaryReader
+		if err := rows.Scan(&r); err != nil {
+			t.Fatal(err)
+		}
+		readers = append(readers, r)
+	}
+	var eof *EOF
+	if err := rows.Err(); !errors.As(err, &eof) {
+		t.Fatal(err)
+	}
+	if len(readers) != 3 || readers[1] != nil {
+		t.Fatalf("want 3 values with a null, got %v", readers)
+	}
+	// The readers remain valid after the rows that delivered them.
+	for i, want := range [][]byte{blob, []byte("ab")} {
+		r := readers[2*i]
+		if r.Len() != len(want) {
+			t.Errorf("value %d: want length %d, got %d", i, len(want), r.Len())
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("value %d: unexpected bytes of length %d", i, len(got))
+		}
+	}
+}
+
+func TestBinaryReaderInvalid(t *testing.T) {
+	_, err := io.ReadAll(newBinaryReader("not base64!"))
+	if err == nil {
+		t.Fatal("want decoding error")
+	}
+}
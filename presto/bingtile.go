@@ -0,0 +1,115 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// BingTile is a tile of the Bing Maps tile system, as values of the
+// bingtile type of the presto geospatial functions are delivered.
+type BingTile struct {
+	X    int `json:"x"`
+	Y    int `json:"y"`
+	Zoom int `json:"zoom"`
+}
+
+// QuadKey returns the quadkey of the tile, as bing_tile_quadkey does.
+func (t BingTile) QuadKey() string {
+	key := make([]byte, t.Zoom)
+	for i := t.Zoom; i > 0; i-- {
+		digit := byte('0')
+		mask := 1 << (i - 1)
+		if t.X&mask != 0 {
+			digit++
+		}
+		if t.Y&mask != 0 {
+			digit += 2
+		}
+		key[t.Zoom-i] = digit
+	}
+	return string(key)
+}
+
+// NullBingTile represents a bingtile value that may be null.
+type NullBingTile struct {
+	BingTile BingTile
+	Valid    bool
+}
+
+// Scan implements the sql.Scanner interface.
+func (n *NullBingTile) Scan(value interface{}) error {
+	if value == nil {
+		*n = NullBingTile{}
+		return nil
+	}
+	t, ok := value.(BingTile)
+	if !ok {
+		var err error
+		if t, err = scanBingTile(value); err != nil {
+			return err
+		}
+	}
+	*n = NullBingTile{BingTile: t, Valid: true}
+	return nil
+}
+
+// scanBingTile returns the tile of a bingtile value as sent by presto, an
+// object with the coordinates and the zoom level of the tile.
+func scanBingTile(v interface{}) (BingTile, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return BingTile{}, fmt.Errorf("presto: cannot convert %v (%T) to BingTile", v, v)
+	}
+	var t BingTile
+	for name, field := range map[string]*int{"x": &t.X, "y": &t.Y, "zoom": &t.Zoom} {
+		n, ok := m[name].(json.Number)
+		if !ok {
+			return BingTile{}, fmt.Errorf("presto: cannot convert %v (%T) to BingTile", v, v)
+		}
+		i, err := n.Int64()
+		if err != nil || i < 0 || i > 1<<30 {
+			return BingTile{}, fmt.Errorf("presto: invalid BingTile %s %v", name, n)
+		}
+		*field = int(i)
+	}
+	return t, nil
+}
+
+// isSketchType reports whether values of the base type are sketches, like
+// HyperLogLog or digests, which are delivered as their serialized bytes.
+func isSketchType(baseType string) bool {
+	switch baseType {
+	case "hyperloglog", "p4hyperloglog", "khyperloglog", "setdigest", "qdigest", "tdigest":
+		return true
+	}
+	return false
+}
+
+// scanSketch returns the bytes of a sketch value, which presto sends
+// encoded in base64 like varbinary values.
+func scanSketch(v interface{}) ([]byte, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("presto: cannot convert %v (%T) to []byte", v, v)
+	}
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("presto: decoding sketch: %w", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,52 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBingTileQuadKey(t *testing.T) {
+	for _, tc := range []struct {
+		tile BingTile
+		want string
+	}{
+		{BingTile{X: 3, Y: 5, Zoom: 3}, "213"},
+		{BingTile{X: 0, Y: 0, Zoom: 1}, "0"},
+		{BingTile{X: 0, Y: 0, Zoom: 0}, ""},
+	} {
+		if got := tc.tile.QuadKey(); got != tc.want {
+			t.Errorf("%+v: want quadkey %q, got %q", tc.tile, tc.want, got)
+		}
+	}
+}
+
+func TestNullBingTile(t *testing.T) {
+	var n NullBingTile
+	if err := n.Scan(BingTile{X: 1, Y: 2, Zoom: 3}); err != nil || !n.Valid || n.BingTile.Y != 2 {
+		t.Fatalf("unexpected scan result %+v: %v", n, err)
+	}
+	if err := n.Scan(nil); err != nil || n.Valid {
+		t.Fatalf("unexpected scan result for null %+v: %v", n, err)
+	}
+	if err := n.Scan(map[string]interface{}{"x": json.Number("1")}); err == nil {
+		t.Fatal("tile without y and zoom scanned with no error")
+	}
+	b, err := json.Marshal(NullBingTile{BingTile: BingTile{X: 3, Y: 5, Zoom: 3}, Valid: true})
+	if err != nil || string(b) != `{"x":3,"y":5,"zoom":3}` {
+		t.Fatalf("unexpected JSON %s: %v", b, err)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Collect scans all rows into a slice of T and closes them.
+//
+// If T is a struct, each column is scanned into the exported field tagged
+// with its name, e.g. `presto:"user_id"`, or else into the field whose name
+// matches it regardless of case. Columns without a field are discarded, but
+// at least one column must have one, and fields tagged `presto:"-"` are
+// ignored. Fields may have any type rows.Scan supports, such as
+// map[string]interface{} for maps and rows, []interface{} or NullSliceInt64
+// for arrays. Otherwise, including for structs scanned as a whole such as
+// time.Time and the sql.Scanner implementations like sql.NullString and
+// NullSliceInt64, the query must return a single column, scanned into T.
+//
+//	type user struct {
+//		ID   int64  `presto:"user_id"`
+//		Name string
+//	}
+//	rows, err := db.Query("SELECT user_id, name FROM users")
+//	...
+//	users, err := presto.Collect[user](rows)
+func Collect[T any](rows *sql.Rows) ([]T, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var zero T
+	typ := reflect.TypeOf(&zero).Elem()
+	var fields []int // index of the field of each column, -1 if none
+	if isRowStruct(typ) {
+		if fields, err = columnFields(typ, columns); err != nil {
+			return nil, err
+		}
+	} else if len(columns) != 1 {
+		return nil, fmt.Errorf("presto: cannot collect %d columns into %v", len(columns), typ)
+	}
+
+	var res []T
+	dest := make([]interface{}, len(columns))
+	for rows.Next() {
+		var v T
+		if fields == nil {
+			dest[0] = &v
+		} else {
+			rv := reflect.ValueOf(&v).Elem()
+			for i, f := range fields {
+				if f < 0 {
+					dest[i] = new(interface{})
+				} else {
+					dest[i] = fieldDest(rv.Field(f))
+				}
+			}
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	var eof *EOF
+	if err := rows.Err(); err != nil && !errors.As(err, &eof) {
+		return nil, err
+	}
+	return res, nil
+}
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+)
+
+// isRowStruct reports whether values of typ are collected from the columns
+// of a row into their fields, rather than scanned as a whole.
+func isRowStruct(typ reflect.Type) bool {
+	return typ.Kind() == reflect.Struct && typ != timeType && !reflect.PtrTo(typ).Implements(scannerType)
+}
+
+// fieldDest returns the destination of rows.Scan for a struct field.
+func fieldDest(v reflect.Value) interface{} {
+	t := v.Type()
+	if (t.Kind() == reflect.Map || t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8) &&
+		!reflect.PtrTo(t).Implements(scannerType) {
+		return nilableField{v}
+	}
+	return v.Addr().Interface()
+}
+
+// nilableField scans values into map and slice fields, which rows.Scan
+// can't set to nil for null values.
+type nilableField struct {
+	v reflect.Value
+}
+
+// Scan implements the sql.Scanner interface.
+func (f nilableField) Scan(src interface{}) error {
+	if src == nil {
+		f.v.Set(reflect.Zero(f.v.Type()))
+		return nil
+	}
+	sv := reflect.ValueOf(src)
+	if !sv.Type().AssignableTo(f.v.Type()) {
+		return fmt.Errorf("presto: cannot store %T into %v", src, f.v.Type())
+	}
+	f.v.Set(sv)
+	return nil
+}
+
+// columnFields returns the index of the field of typ each column is
+// scanned into, -1 for columns without a field.
+func columnFields(typ reflect.Type, columns []string) ([]int, error) {
+	tagged := make(map[string]int)
+	named := make(map[string]int)
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		switch tag := f.Tag.Get("presto"); tag {
+		case "-":
+		case "":
+			named[strings.ToLower(f.Name)] = i
+		default:
+			if _, ok := tagged[tag]; ok {
+				return nil, fmt.Errorf("presto: duplicate tag %q in %v", tag, typ)
+			}
+			tagged[tag] = i
+		}
+	}
+	fields := make([]int, len(columns))
+	for i, c := range columns {
+		f, ok := tagged[c]
+		if !ok {
+			if f, ok = named[strings.ToLower(c)]; !ok {
+				f = -1
+			}
+		}
+		fields[i] = f
+	}
+	for _, f := range fields {
+		if f >= 0 {
+			return fields, nil
+		}
+	}
+	return nil, fmt.Errorf("presto: no field of %v matches the columns %q", typ, columns)
+}
@@ -0,0 +1,164 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCollect(t *testing.T) {
+	columns := []queryColumn{
+		{Name: "user_id", Type: "bigint", TypeSignature: typeSignature{RawType: "bigint"}},
+		{Name: "NAME", Type: "varchar", TypeSignature: typeSignature{RawType: "varchar"}},
+		{Name: "tags", Type: "array(varchar)", TypeSignature: typeSignature{RawType: "array"}},
+		{Name: "attrs", Type: "map(varchar,varchar)", TypeSignature: typeSignature{RawType: "map"}},
+		{Name: "ignored", Type: "bigint", TypeSignature: typeSignature{RawType: "bigint"}},
+	}
+	ts := newPagedServer(queryResponse{Columns: columns, Data: []queryData{
+		{json.Number("1"), "alice", []interface{}{"a", "b"}, map[string]interface{}{"k": "v"}, json.Number("0")},
+		{json.Number("2"), "bob", nil, nil, json.Number("0")},
+	}})
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	type user struct {
+		ID      int64 `presto:"user_id"`
+		Name    string
+		Tags    NullSliceString
+		Attrs   map[string]interface{}
+		Ignored int64 `presto:"-"`
+		secret  string
+	}
+	rows, err := db.Query("SELECT *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := Collect[user](rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []user{
+		{
+			ID:    1,
+			Name:  "alice",
+			Tags:  NullSliceString{SliceString: []sql.NullString{{String: "a", Valid: true}, {String: "b", Valid: true}}, Valid: true},
+			Attrs: map[string]interface{}{"k": "v"},
+		},
+		{ID: 2, Name: "bob"},
+	}
+	if !reflect.DeepEqual(users, want) {
+		t.Fatalf("unexpected users:\nhave %+v\nwant %+v", users, want)
+	}
+
+	if rows, err = db.Query("SELECT *"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Collect[int64](rows); err == nil {
+		t.Fatal("collected several columns into a scalar")
+	}
+}
+
+func TestCollectScalar(t *testing.T) {
+	columns := []queryColumn{{Name: "x", Type: "bigint", TypeSignature: typeSignature{RawType: "bigint"}}}
+	ts := newPagedServer(
+		queryResponse{Columns: columns, Data: []queryData{{json.Number("1")}}},
+		queryResponse{Columns: columns, Data: []queryData{{json.Number("2")}}},
+	)
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xs, err := Collect[int64](rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{1, 2}; !reflect.DeepEqual(xs, want) {
+		t.Fatalf("want %v, got %v", want, xs)
+	}
+}
+
+func TestCollectScalarStruct(t *testing.T) {
+	columns := []queryColumn{{Name: "x", Type: "varchar", TypeSignature: typeSignature{RawType: "varchar"}}}
+	ts := newPagedServer(queryResponse{Columns: columns, Data: []queryData{{"a"}, {nil}}})
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xs, err := Collect[sql.NullString](rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []sql.NullString{{String: "a", Valid: true}, {}}; !reflect.DeepEqual(xs, want) {
+		t.Fatalf("want %v, got %v", want, xs)
+	}
+
+	columns = []queryColumn{{Name: "t", Type: "timestamp", TypeSignature: typeSignature{RawType: "timestamp"}}}
+	ts = newPagedServer(queryResponse{Columns: columns, Data: []queryData{{"2017-07-10 01:02:03.000"}}})
+	defer ts.Close()
+	db, err = sql.Open("presto", ts.URL+"?legacy_timestamp=false")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err = db.Query("SELECT t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	times, err := Collect[time.Time](rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2017, 7, 10, 1, 2, 3, 0, time.UTC); len(times) != 1 || !times[0].Equal(want) {
+		t.Fatalf("want %v, got %v", want, times)
+	}
+}
+
+func TestCollectNoField(t *testing.T) {
+	columns := []queryColumn{{Name: "x", Type: "bigint", TypeSignature: typeSignature{RawType: "bigint"}}}
+	ts := newPagedServer(queryResponse{Columns: columns, Data: []queryData{{json.Number("1")}}})
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Collect[struct{ Y int64 }](rows); err == nil {
+		t.Fatal("want error for a struct without a field of the columns")
+	}
+}
@@ -0,0 +1,435 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type contextKey int
+
+const (
+	catalogSchemaKey contextKey = iota
+	idempotentRetriesKey
+	traceTokenKey
+	coordinatorAffinityKey
+	rawValuesKey
+	resultLocationKey
+	queryStartedKey
+	transactionIDKey
+	httpClientKey
+	pageObserverKey
+	coordinatorKey
+	rawBytesKey
+	columnDecodersKey
+	retryBudgetKey
+	resourceGroupKey
+	progressKey
+	exactlyOnceKey
+	binaryStreamsKey
+	labelsKey
+	execResultKey
+	authStateKey
+	typedMapKeysKey
+)
+
+type catalogSchema struct {
+	catalog string
+	schema  string
+}
+
+// WithCatalogSchema returns a copy of ctx that runs queries against the
+// given catalog and schema instead of the ones configured in the DSN, so a
+// single sql.DB can serve several of them. Empty values keep the connection
+// defaults.
+func WithCatalogSchema(ctx context.Context, catalog, schema string) context.Context {
+	return context.WithValue(ctx, catalogSchemaKey, catalogSchema{catalog: catalog, schema: schema})
+}
+
+// ResourceGroupSelection holds the attributes of queries the selectors of
+// presto resource groups match on, to route queries to a resource group.
+// Empty fields keep the values of the connection.
+type ResourceGroupSelection struct {
+	// Source replaces the source of the connection, matched by the source
+	// regexes of selectors.
+	Source string
+	// ClientTags are the client tags of the queries, which must include
+	// all the tags of a selector for it to match. Tags can't contain
+	// commas.
+	ClientTags []string
+	// User is the user the queries run as, matched by the user regexes of
+	// selectors. The principal of the connection must be allowed to
+	// impersonate it.
+	User string
+	// ExecutionTime, CPUTime and PeakMemory, in bytes, are the resource
+	// estimates matched by the selectorResourceEstimate of selectors.
+	ExecutionTime time.Duration
+	CPUTime       time.Duration
+	PeakMemory    int64
+}
+
+// WithResourceGroupSelection returns a copy of ctx whose queries are sent
+// with the headers of the attributes of sel, so they are selected into the
+// resource group whose selector matches them all.
+func WithResourceGroupSelection(ctx context.Context, sel ResourceGroupSelection) context.Context {
+	return context.WithValue(ctx, resourceGroupKey, sel)
+}
+
+// headers sets the headers of the non-empty attributes of the selection on
+// hs, except the ones already set by the arguments of the query.
+func (sel ResourceGroupSelection) headers(hs http.Header) {
+	set := func(name, value string) {
+		if value != "" && hs.Get(name) == "" {
+			hs.Set(name, value)
+		}
+	}
+	set(prestoSourceHeader, sel.Source)
+	set(prestoClientTagsHeader, strings.Join(sel.ClientTags, ","))
+	set(prestoUserHeader, sel.User)
+	var estimates []string
+	if sel.ExecutionTime > 0 {
+		estimates = append(estimates, fmt.Sprintf("EXECUTION_TIME=%dms", sel.ExecutionTime.Milliseconds()))
+	}
+	if sel.CPUTime > 0 {
+		estimates = append(estimates, fmt.Sprintf("CPU_TIME=%dms", sel.CPUTime.Milliseconds()))
+	}
+	if sel.PeakMemory > 0 {
+		estimates = append(estimates, fmt.Sprintf("PEAK_MEMORY=%dB", sel.PeakMemory))
+	}
+	set(prestoResourceEstimateHeader, strings.Join(estimates, ","))
+}
+
+// WithLabel returns a copy of ctx whose queries are labelled with key and
+// value, in addition to the labels of ctx, replacing a label with the same
+// key. Labels are encoded the same way for all queries, so chargeback tools
+// can parse them from system.runtime.queries and the event listeners: as a
+// client tag "key=value" each, and in the client info as a JSON document
+// {"labels":{"key":"value"}}, which holds any client info set otherwise in
+// "info". Keys can't contain '=' and neither keys nor values commas, since
+// client tags are separated by commas; queries with such labels fail.
+func WithLabel(ctx context.Context, key, value string) context.Context {
+	old, _ := ctx.Value(labelsKey).(map[string]string)
+	labels := make(map[string]string, len(old)+1)
+	for k, v := range old {
+		labels[k] = v
+	}
+	labels[key] = value
+	return context.WithValue(ctx, labelsKey, labels)
+}
+
+// labelHeaders adds the client tags and client info of the labels of ctx
+// to hs, merged with the ones of hs or else of the connection.
+func (c *Conn) labelHeaders(ctx context.Context, hs http.Header) error {
+	labels, _ := ctx.Value(labelsKey).(map[string]string)
+	if len(labels) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(labels))
+	for k, v := range labels {
+		if k == "" || strings.ContainsAny(k, "=,") || strings.Contains(v, ",") {
+			return fmt.Errorf("presto: invalid label: %q=%q", k, v)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tags := hs.Get(prestoClientTagsHeader)
+	if tags == "" {
+		tags = c.httpHeaders.Get(prestoClientTagsHeader)
+	}
+	for _, k := range keys {
+		if tags != "" {
+			tags += ","
+		}
+		tags += k + "=" + labels[k]
+	}
+	hs.Set(prestoClientTagsHeader, tags)
+	info := struct {
+		Labels map[string]string `json:"labels"`
+		Info   string            `json:"info,omitempty"`
+	}{Labels: labels, Info: hs.Get(prestoClientInfoHeader)}
+	if info.Info == "" {
+		info.Info = c.httpHeaders.Get(prestoClientInfoHeader)
+	}
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	hs.Set(prestoClientInfoHeader, string(b))
+	return nil
+}
+
+// WithIdempotentRetries returns a copy of ctx that flags its queries as
+// idempotent, allowing the driver to re-execute them up to retries times
+// when fetching a page of results fails mid-stream, e.g. because a worker
+// died. The rows delivered before the failure are skipped in the new
+// result, so the query must return the same rows in the same order.
+func WithIdempotentRetries(ctx context.Context, retries int) context.Context {
+	return context.WithValue(ctx, idempotentRetriesKey, retries)
+}
+
+func idempotentRetries(ctx context.Context) int {
+	retries, _ := ctx.Value(idempotentRetriesKey).(int)
+	return retries
+}
+
+// WithExactlyOnce returns a copy of ctx whose queries never deliver a row
+// twice, for consumers that can't tolerate duplicates, e.g. when the query
+// isn't deterministic. Queries run with WithIdempotentRetries are only
+// re-executed until they deliver their first row; failures after that end
+// the rows with an ErrRowsDelivered.
+func WithExactlyOnce(ctx context.Context) context.Context {
+	return context.WithValue(ctx, exactlyOnceKey, true)
+}
+
+func exactlyOnce(ctx context.Context) bool {
+	once, _ := ctx.Value(exactlyOnceKey).(bool)
+	return once
+}
+
+// WithTraceToken returns a copy of ctx that sends the given trace token with
+// its queries, to correlate them with the application's own tracing in the
+// presto logs and event listeners.
+func WithTraceToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, traceTokenKey, token)
+}
+
+// WithCoordinatorAffinity returns a copy of ctx whose queries are all
+// submitted to the same coordinator, among the ones configured in the DSN,
+// as the queries of any other context with the same key. Use it to keep a
+// logical session on one host when it spans several connections.
+func WithCoordinatorAffinity(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, coordinatorAffinityKey, key)
+}
+
+// WithRawValues returns a copy of ctx whose queries deliver every value as
+// a string holding its representation in the presto protocol, without type
+// conversion: numbers as in JSON, and arrays, maps and rows as JSON
+// documents. Use it for copy jobs that parse the values downstream.
+func WithRawValues(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rawValuesKey, true)
+}
+
+func rawValues(ctx context.Context) bool {
+	raw, _ := ctx.Value(rawValuesKey).(bool)
+	return raw
+}
+
+// WithRawBytes returns a copy of ctx whose queries deliver char, varchar,
+// json and varbinary values as []byte, decoded in the case of varbinary,
+// in a buffer reused for every row. Scanning them into sql.RawBytes then
+// doesn't allocate, for high-throughput consumers; the bytes are only valid
+// until the next call to Next. Other destinations receive copies as usual,
+// but interface{} ones []byte rather than strings.
+func WithRawBytes(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rawBytesKey, true)
+}
+
+func rawBytes(ctx context.Context) bool {
+	raw, _ := ctx.Value(rawBytesKey).(bool)
+	return raw
+}
+
+// WithBinaryStreams returns a copy of ctx whose queries deliver varbinary
+// values as *BinaryReader, which decode them as they are read, to copy
+// blob-like columns to a writer without a decoded copy of each. The
+// encoded values are held in memory with their pages. Scan them into a *BinaryReader
+// variable, which is nil for nulls:
+//
+//	var blob *presto.BinaryReader
+//	if err := rows.Scan(&id, &blob); err != nil {
+//		return err
+//	}
+//	if blob != nil {
+//		_, err = io.Copy(w, blob)
+//	}
+//
+// It takes precedence over WithRawBytes for varbinary columns.
+func WithBinaryStreams(ctx context.Context) context.Context {
+	return context.WithValue(ctx, binaryStreamsKey, true)
+}
+
+func binaryStreams(ctx context.Context) bool {
+	streams, _ := ctx.Value(binaryStreamsKey).(bool)
+	return streams
+}
+
+// WithTypedMapKeys returns a copy of ctx whose queries deliver maps with
+// integer, real or double, and boolean keys as map[int64]any,
+// map[float64]any and map[bool]any, rather than with the string keys of the
+// presto protocol, including maps nested in arrays, rows and other maps.
+// NullMap and the slices of NullMap still scan them with string keys.
+func WithTypedMapKeys(ctx context.Context) context.Context {
+	return context.WithValue(ctx, typedMapKeysKey, true)
+}
+
+func typedMapKeys(ctx context.Context) bool {
+	typed, _ := ctx.Value(typedMapKeysKey).(bool)
+	return typed
+}
+
+// WithColumnDecoders returns a copy of ctx whose queries convert the values
+// of the columns with the given names with their decoder instead of the
+// driver's conversion, e.g. to keep a timestamp column as a string with
+// RawDecoder, or to parse a varchar column holding JSON. Decoders receive
+// the values as decoded from the JSON of the presto protocol, nil for
+// nulls.
+func WithColumnDecoders(ctx context.Context, decoders map[string]driver.ValueConverter) context.Context {
+	return context.WithValue(ctx, columnDecodersKey, decoders)
+}
+
+func columnDecoders(ctx context.Context) map[string]driver.ValueConverter {
+	decoders, _ := ctx.Value(columnDecodersKey).(map[string]driver.ValueConverter)
+	return decoders
+}
+
+// WithResultLocation returns a copy of ctx whose queries return every
+// time.Time value in loc, e.g. a tenant's display zone. Values with a time
+// zone are converted to loc, and values without one are interpreted in it
+// instead of the connection's location.
+func WithResultLocation(ctx context.Context, loc *time.Location) context.Context {
+	return context.WithValue(ctx, resultLocationKey, loc)
+}
+
+func resultLocation(ctx context.Context) *time.Location {
+	loc, _ := ctx.Value(resultLocationKey).(*time.Location)
+	return loc
+}
+
+// QueryHandle identifies a running query and cancels it from any
+// goroutine, without owning the query context.
+type QueryHandle struct {
+	QueryID string
+	cancel  context.CancelFunc
+}
+
+// Cancel cancels the query, like cancelling its context. It has no effect
+// once the query has ended.
+func (h *QueryHandle) Cancel() {
+	h.cancel()
+}
+
+// WithQueryStarted returns a copy of ctx that calls started with a handle
+// to each of its queries once submitted, e.g. to register them for an admin
+// endpoint that cancels queries by ID. started runs before the query
+// returns its rows, so it must not block.
+func WithQueryStarted(ctx context.Context, started func(*QueryHandle)) context.Context {
+	return context.WithValue(ctx, queryStartedKey, started)
+}
+
+func queryStarted(ctx context.Context) func(*QueryHandle) {
+	started, _ := ctx.Value(queryStartedKey).(func(*QueryHandle))
+	return started
+}
+
+// WithProgress returns a copy of ctx that calls progress with the progress
+// of its queries on every response from presto, e.g. for interactive UIs to
+// show the queue position of queued queries. progress runs while the query
+// fetches its results, so it must not block.
+func WithProgress(ctx context.Context, progress func(QueryProgress)) context.Context {
+	return context.WithValue(ctx, progressKey, progress)
+}
+
+func progressFunc(ctx context.Context) func(QueryProgress) {
+	progress, _ := ctx.Value(progressKey).(func(QueryProgress))
+	return progress
+}
+
+// joinCancel returns a function calling both cancel functions, the second
+// of which may be nil.
+func joinCancel(a, b context.CancelFunc) context.CancelFunc {
+	if b == nil {
+		return a
+	}
+	return func() {
+		a()
+		b()
+	}
+}
+
+// WithTransactionID returns a copy of ctx whose queries run in the existing
+// transaction with the given ID, started elsewhere, e.g. by another step of
+// a distributed workflow. The transaction is not committed or rolled back
+// by the driver.
+func WithTransactionID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, transactionIDKey, id)
+}
+
+// inTransaction reports whether queries of ctx on the connection run in a
+// transaction, which ties them to the coordinator that started it.
+func (c *Conn) inTransaction(ctx context.Context) bool {
+	if id, _ := ctx.Value(transactionIDKey).(string); id != "" {
+		return true
+	}
+	return c.httpHeaders.Get(prestoTransactionHeader) != ""
+}
+
+// WithHTTPClient returns a copy of ctx whose queries send their requests
+// with client instead of the client of the connection, e.g. to use another
+// transport or timeout. Without a Timeout, the client times out with the
+// deadline of the query context, like the client of the connection.
+func WithHTTPClient(ctx context.Context, client *http.Client) context.Context {
+	return context.WithValue(ctx, httpClientKey, client)
+}
+
+// clientFor returns a copy of the HTTP client for the requests of ctx, and
+// whether it was set by WithHTTPClient.
+func (c *Conn) clientFor(ctx context.Context) (http.Client, bool) {
+	if client, _ := ctx.Value(httpClientKey).(*http.Client); client != nil {
+		return *client, true
+	}
+	return c.httpClient, false
+}
+
+// WithPageObserver returns a copy of ctx whose queries pass every response
+// of the client protocol to observe before it is decoded, e.g. to capture
+// golden files or to check the compatibility of new server versions.
+// observe runs in the goroutine reading the rows, and must not modify the
+// page.
+func WithPageObserver(ctx context.Context, observe func(Page)) context.Context {
+	return context.WithValue(ctx, pageObserverKey, observe)
+}
+
+// contextHeaders adds the headers derived from the query context to hs,
+// allocating it if needed.
+func contextHeaders(ctx context.Context, hs http.Header) http.Header {
+	if hs == nil {
+		hs = make(http.Header)
+	}
+	if cs, ok := ctx.Value(catalogSchemaKey).(catalogSchema); ok {
+		if cs.catalog != "" {
+			hs.Set(prestoCatalogHeader, cs.catalog)
+		}
+		if cs.schema != "" {
+			hs.Set(prestoSchemaHeader, cs.schema)
+		}
+	}
+	if token, _ := ctx.Value(traceTokenKey).(string); token != "" {
+		hs.Set(prestoTraceTokenHeader, token)
+	}
+	if id, _ := ctx.Value(transactionIDKey).(string); id != "" {
+		hs.Set(prestoTransactionHeader, id)
+	}
+	if sel, ok := ctx.Value(resourceGroupKey).(ResourceGroupSelection); ok {
+		sel.headers(hs)
+	}
+	return hs
+}
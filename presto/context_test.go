@@ -0,0 +1,786 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newHeaderRecorder returns a test server that serves an empty result and
+// records the headers of every submitted statement.
+func newHeaderRecorder(headers *[]http.Header) *httptest.Server {
+	ts := newPagedServer(queryResponse{})
+	ts.Config.Handler = func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				*headers = append(*headers, r.Header.Clone())
+			}
+			h.ServeHTTP(w, r)
+		})
+	}(ts.Config.Handler)
+	return ts
+}
+
+func TestWithCatalogSchema(t *testing.T) {
+	var headers []http.Header
+	ts := newHeaderRecorder(&headers)
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL+"?catalog=hive&schema=default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, ctx := range []context.Context{
+		context.Background(),
+		WithCatalogSchema(context.Background(), "tpch", "sf1"),
+		WithCatalogSchema(context.Background(), "", "web"),
+	} {
+		rows, err := db.QueryContext(ctx, "SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+
+	want := [][2]string{{"hive", "default"}, {"tpch", "sf1"}, {"hive", "web"}}
+	if len(headers) != len(want) {
+		t.Fatalf("want %d queries, got %d", len(want), len(headers))
+	}
+	for i, h := range headers {
+		got := [2]string{h.Get(prestoCatalogHeader), h.Get(prestoSchemaHeader)}
+		if got != want[i] {
+			t.Errorf("query %d: want catalog and schema %q, got %q", i, want[i], got)
+		}
+	}
+}
+
+func TestWithResourceGroupSelection(t *testing.T) {
+	var headers []http.Header
+	ts := newHeaderRecorder(&headers)
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL+"?source=app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := WithResourceGroupSelection(context.Background(), ResourceGroupSelection{
+		Source:        "etl-nightly",
+		ClientTags:    []string{"etl", "low-priority"},
+		User:          "batch",
+		ExecutionTime: 5 * time.Minute,
+		PeakMemory:    8 << 30,
+	})
+	rows, err := db.QueryContext(ctx, "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	rows, err = db.QueryContext(ctx, "SELECT 1", sql.Named(prestoClientTagsHeader, "adhoc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	rows, err = db.Query("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	for i, want := range []map[string]string{
+		{
+			prestoSourceHeader:           "etl-nightly",
+			prestoClientTagsHeader:       "etl,low-priority",
+			prestoUserHeader:             "batch",
+			prestoResourceEstimateHeader: "EXECUTION_TIME=300000ms,PEAK_MEMORY=8589934592B",
+		},
+		{prestoSourceHeader: "etl-nightly", prestoClientTagsHeader: "'adhoc'"},
+		{prestoSourceHeader: "app", prestoClientTagsHeader: "", prestoResourceEstimateHeader: ""},
+	} {
+		for name, value := range want {
+			if got := headers[i].Get(name); got != value {
+				t.Errorf("query %d: want %s %q, got %q", i, name, value, got)
+			}
+		}
+	}
+}
+
+func TestWithIdempotentRetries(t *testing.T) {
+	columns := []queryColumn{{Name: "x", Type: "bigint", TypeSignature: typeSignature{RawType: "bigint"}}}
+	executions := 0
+	shortRerun := false
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost:
+			executions++
+			json.NewEncoder(w).Encode(&stmtResponse{
+				ID:      "test_query",
+				NextURI: fmt.Sprintf("%s/v1/statement/%d/0", ts.URL, executions),
+			})
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		case shortRerun && r.URL.Path == "/v1/statement/2/0":
+			json.NewEncoder(w).Encode(&queryResponse{
+				ID:      "test_query",
+				Columns: columns,
+				Data:    []queryData{{json.Number("1")}},
+			})
+		case strings.HasSuffix(r.URL.Path, "/0"):
+			json.NewEncoder(w).Encode(&queryResponse{
+				ID:      "test_query",
+				NextURI: ts.URL + strings.TrimSuffix(r.URL.Path, "0") + "1",
+				Columns: columns,
+				Data:    []queryData{{json.Number("1")}, {json.Number("2")}},
+			})
+		case r.URL.Path == "/v1/statement/1/1":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			json.NewEncoder(w).Encode(&queryResponse{
+				ID:      "test_query",
+				Columns: columns,
+				Data:    []queryData{{json.Number("3")}},
+			})
+		}
+	}))
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	collect := func(ctx context.Context) ([]int64, error) {
+		executions = 0
+		rows, err := db.QueryContext(ctx, "SELECT x")
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var xs []int64
+		for rows.Next() {
+			var x int64
+			if err := rows.Scan(&x); err != nil {
+				return nil, err
+			}
+			xs = append(xs, x)
+		}
+		var eof *EOF
+		if err := rows.Err(); !errors.As(err, &eof) {
+			return xs, err
+		}
+		return xs, nil
+	}
+
+	if _, err := collect(context.Background()); err == nil {
+		t.Fatal("query failing mid-stream succeeded without retries")
+	}
+	xs, err := collect(WithIdempotentRetries(context.Background(), 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{1, 2, 3}; !reflect.DeepEqual(xs, want) {
+		t.Fatalf("want rows %v, got %v", want, xs)
+	}
+	if executions != 2 {
+		t.Fatalf("want 2 executions, got %d", executions)
+	}
+
+	xs, err = collect(WithExactlyOnce(WithIdempotentRetries(context.Background(), 1)))
+	var rd *ErrRowsDelivered
+	if !errors.As(err, &rd) || rd.Rows != 2 || rd.QueryID != "test_query" {
+		t.Fatal("want rows delivered error, got", err)
+	}
+	if want := []int64{1, 2}; !reflect.DeepEqual(xs, want) || executions != 1 {
+		t.Fatalf("want rows %v of a single execution, got %v of %d", want, xs, executions)
+	}
+
+	shortRerun = true
+	_, err = collect(WithIdempotentRetries(context.Background(), 1))
+	var sr *ErrShortResult
+	if !errors.As(err, &sr) || sr.Rows != 2 || sr.QueryID != "test_query" {
+		t.Fatal("want short result error, got", err)
+	}
+}
+
+func TestWithLabel(t *testing.T) {
+	var headers []http.Header
+	ts := newHeaderRecorder(&headers)
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL+"?client_tags=etl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := WithLabel(WithLabel(WithLabel(context.Background(), "team", "ads"), "cost_center", "42"), "team", "growth")
+	rows, err := db.QueryContext(ctx, "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	h := headers[0]
+	if got, want := h.Get(prestoClientTagsHeader), "etl,cost_center=42,team=growth"; got != want {
+		t.Errorf("want client tags %q, got %q", want, got)
+	}
+	if got, want := h.Get(prestoClientInfoHeader), `{"labels":{"cost_center":"42","team":"growth"}}`; got != want {
+		t.Errorf("want client info %s, got %s", want, got)
+	}
+
+	rows, err = db.QueryContext(ctx, "SELECT 1", sql.Named(prestoClientInfoHeader, "nightly"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	var info struct {
+		Labels map[string]string
+		Info   string
+	}
+	if err := json.Unmarshal([]byte(headers[1].Get(prestoClientInfoHeader)), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Labels["team"] != "growth" || info.Info == "" {
+		t.Errorf("want labels and the client info of the query, got %+v", info)
+	}
+
+	if _, err := db.QueryContext(WithLabel(ctx, "owner", "a,b"), "SELECT 1"); err == nil {
+		t.Error("want error for label with a comma")
+	}
+}
+
+type requestIDKey struct{}
+
+func TestTraceToken(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		traceToken string
+		want       []string
+	}{
+		{name: "unset", want: []string{"", "req-1", "explicit"}},
+		{name: "configured", traceToken: "batch-job", want: []string{"batch-job", "req-1", "explicit"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var headers []http.Header
+			ts := newHeaderRecorder(&headers)
+			defer ts.Close()
+			connector, err := NewConnector(&Config{
+				PrestoURI:  ts.URL,
+				TraceToken: tc.traceToken,
+				TraceTokenFunc: func(ctx context.Context) string {
+					id, _ := ctx.Value(requestIDKey{}).(string)
+					return id
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			db := sql.OpenDB(connector)
+			defer db.Close()
+
+			for _, ctx := range []context.Context{
+				context.Background(),
+				context.WithValue(context.Background(), requestIDKey{}, "req-1"),
+				WithTraceToken(context.WithValue(context.Background(), requestIDKey{}, "req-2"), "explicit"),
+			} {
+				rows, err := db.QueryContext(ctx, "SELECT 1")
+				if err != nil {
+					t.Fatal(err)
+				}
+				rows.Close()
+			}
+
+			if len(headers) != len(tc.want) {
+				t.Fatalf("want %d queries, got %d", len(tc.want), len(headers))
+			}
+			for i, h := range headers {
+				if got := h.Get(prestoTraceTokenHeader); got != tc.want[i] {
+					t.Errorf("query %d: want trace token %q, got %q", i, tc.want[i], got)
+				}
+			}
+		})
+	}
+
+	dsn, err := (&Config{PrestoURI: "http://localhost:8080", TraceToken: "batch-job"}).FormatDSN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dsn, "trace_token=batch-job") {
+		t.Fatal("unexpected dsn:", dsn)
+	}
+}
+
+func TestWithRawValues(t *testing.T) {
+	columns := []queryColumn{
+		{Name: "n", Type: "decimal(38,2)", TypeSignature: typeSignature{RawType: "decimal"}},
+		{Name: "d", Type: "double", TypeSignature: typeSignature{RawType: "double"}},
+		{Name: "b", Type: "boolean", TypeSignature: typeSignature{RawType: "boolean"}},
+		{Name: "ts", Type: "timestamp", TypeSignature: typeSignature{RawType: "timestamp"}},
+		{Name: "a", Type: "array(bigint)", TypeSignature: typeSignature{RawType: "array"}},
+		{Name: "z", Type: "varchar", TypeSignature: typeSignature{RawType: "varchar"}},
+	}
+	ts := newPagedServer(queryResponse{Columns: columns, Data: []queryData{{
+		"12.30", json.Number("1.5e300"), true, "2017-07-10 01:02:03.000",
+		[]interface{}{json.Number("1"), nil}, nil,
+	}}})
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.QueryContext(WithRawValues(context.Background()), "SELECT *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+	var n, d, b, tm, a string
+	var z sql.NullString
+	if err = rows.Scan(&n, &d, &b, &tm, &a, &z); err != nil {
+		t.Fatal(err)
+	}
+	got := []string{n, d, b, tm, a}
+	want := []string{"12.30", "1.5e300", "true", "2017-07-10 01:02:03.000", "[1,null]"}
+	if !reflect.DeepEqual(got, want) || z.Valid {
+		t.Fatalf("unexpected raw values: %q, %v", got, z)
+	}
+}
+
+func TestWithResultLocation(t *testing.T) {
+	columns := []queryColumn{
+		{Name: "tz", Type: "timestamp with time zone", TypeSignature: typeSignature{RawType: "timestamp with time zone"}},
+		{Name: "ts", Type: "timestamp", TypeSignature: typeSignature{RawType: "timestamp"}},
+	}
+	ts := newPagedServer(queryResponse{Columns: columns, Data: []queryData{
+		{"2017-07-10 01:02:03.000 UTC", "2017-07-10 01:02:03.000"},
+	}})
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	loc, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip(err)
+	}
+	rows, err := db.QueryContext(WithResultLocation(context.Background(), loc), "SELECT *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+	var tz, tm time.Time
+	if err = rows.Scan(&tz, &tm); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2017, 7, 10, 10, 2, 3, 0, loc); !tz.Equal(want) || tz.Location() != loc {
+		t.Errorf("want %v, got %v", want, tz)
+	}
+	if want := time.Date(2017, 7, 10, 1, 2, 3, 0, loc); !tm.Equal(want) || tm.Location() != loc {
+		t.Errorf("want %v, got %v", want, tm)
+	}
+}
+
+func TestWithProgress(t *testing.T) {
+	columns := []queryColumn{{Name: "x", Type: "bigint", TypeSignature: typeSignature{RawType: "bigint"}}}
+	ts := newPagedServer(
+		queryResponse{Stats: stmtStats{State: "QUEUED", Queued: true, QueuePosition: 12, ResourceGroupID: []string{"global", "adhoc"}, QueuedTimeMillis: 1500}},
+		queryResponse{Stats: stmtStats{State: "QUEUED", QueuePosition: 3, ResourceGroupID: []string{"global", "adhoc"}}},
+		queryResponse{Columns: columns, Data: []queryData{{json.Number("1")}}, Stats: stmtStats{State: "FINISHED", TotalSplits: 4, CompletedSplits: 4}},
+	)
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL+"?empty_page_min_backoff=1ms&empty_page_max_backoff=1ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var progress []QueryProgress
+	ctx := WithProgress(context.Background(), func(p QueryProgress) {
+		progress = append(progress, p)
+	})
+	rows, err := db.QueryContext(ctx, "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+
+	want := []QueryProgress{
+		{QueryID: "test_query"},
+		{QueryID: "test_query", State: "QUEUED", Queued: true, QueuedTime: 1500 * time.Millisecond, QueuePosition: 12, ResourceGroup: []string{"global", "adhoc"}},
+		{QueryID: "test_query", State: "QUEUED", Queued: true, QueuePosition: 3, ResourceGroup: []string{"global", "adhoc"}},
+		{QueryID: "test_query", State: "FINISHED", TotalSplits: 4, CompletedSplits: 4},
+	}
+	if !reflect.DeepEqual(progress, want) {
+		t.Fatalf("want progress %+v, got %+v", want, progress)
+	}
+}
+
+func TestWithQueryStarted(t *testing.T) {
+	columns := []queryColumn{{Name: "x", Type: "bigint", TypeSignature: typeSignature{RawType: "bigint"}}}
+	ts := newPagedServer(
+		queryResponse{Columns: columns, Data: []queryData{{json.Number("1")}, {json.Number("2")}}},
+		queryResponse{Columns: columns, Data: []queryData{{json.Number("3")}}},
+	)
+	deletes := 0
+	ts.Config.Handler = func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodDelete {
+				deletes++
+			}
+			h.ServeHTTP(w, r)
+		})
+	}(ts.Config.Handler)
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	handles := make(chan *QueryHandle, 1)
+	ctx := WithQueryStarted(context.Background(), func(h *QueryHandle) {
+		handles <- h
+	})
+	rows, err := db.QueryContext(ctx, "SELECT x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	h := <-handles
+	if h.QueryID != "test_query" {
+		t.Fatalf("want handle of test_query, got %q", h.QueryID)
+	}
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+	done := make(chan struct{})
+	go func() {
+		h.Cancel()
+		close(done)
+	}()
+	<-done
+	if rows.Next() {
+		t.Fatal("rows delivered after the query was cancelled")
+	}
+	var qa *ErrQueryAbandoned
+	if err := rows.Err(); !errors.Is(err, context.Canceled) || !errors.As(err, &qa) || !qa.Acknowledged {
+		t.Fatalf("want acknowledged cancellation, got %v", err)
+	}
+	if deletes != 1 {
+		t.Fatalf("want the query cancelled once, got %d deletes", deletes)
+	}
+}
+
+func TestWithTransactionID(t *testing.T) {
+	var headers []http.Header
+	ts := newHeaderRecorder(&headers)
+	defer ts.Close()
+	for _, tc := range []struct {
+		dsn  string
+		ctx  context.Context
+		want string
+	}{
+		{ts.URL, context.Background(), ""},
+		{ts.URL, WithTransactionID(context.Background(), "txn-1"), "txn-1"},
+		{ts.URL + "?transaction_id=txn-2", context.Background(), "txn-2"},
+		{ts.URL + "?transaction_id=txn-2", WithTransactionID(context.Background(), "txn-3"), "txn-3"},
+	} {
+		headers = nil
+		db, err := sql.Open("presto", tc.dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := db.QueryContext(tc.ctx, "SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+		db.Close()
+		if got := headers[0].Get(prestoTransactionHeader); got != tc.want {
+			t.Errorf("%s: want transaction %q, got %q", tc.dsn, tc.want, got)
+		}
+	}
+}
+
+func TestDefaultUser(t *testing.T) {
+	var headers []http.Header
+	ts := newHeaderRecorder(&headers)
+	defer ts.Close()
+	for _, tc := range []struct {
+		dsn  string
+		want string
+	}{
+		{strings.Replace(ts.URL, "://", "://alice@", 1) + "?user=bob", "alice"},
+		{ts.URL + "?user=bob", "bob"},
+		{ts.URL, processUser()},
+	} {
+		headers = nil
+		db, err := sql.Open("presto", tc.dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := db.Query("SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+		db.Close()
+		if got := headers[0].Get(prestoUserHeader); got != tc.want || got == "" {
+			t.Errorf("%s: want user %q, got %q", tc.dsn, tc.want, got)
+		}
+	}
+}
+
+func TestClientIdentification(t *testing.T) {
+	var headers []http.Header
+	ts := newHeaderRecorder(&headers)
+	defer ts.Close()
+	for _, tc := range []struct {
+		params    string
+		source    string
+		userAgent string
+	}{
+		{"", "", "presto-go-client"},
+		{"?source=etl", "etl", "presto-go-client"},
+		{"?source_suffix=etl/2&user_agent=etl/2", "presto-go-client etl/2", "etl/2 presto-go-client"},
+		{"?source=etl&source_suffix=nightly", "etl nightly", "presto-go-client"},
+	} {
+		headers = nil
+		db, err := sql.Open("presto", ts.URL+tc.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := db.Query("SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+		db.Close()
+		if got := headers[0].Get(prestoSourceHeader); got != tc.source {
+			t.Errorf("%q: want source %q, got %q", tc.params, tc.source, got)
+		}
+		if got := headers[0].Get("User-Agent"); got != tc.userAgent {
+			t.Errorf("%q: want user agent %q, got %q", tc.params, tc.userAgent, got)
+		}
+	}
+}
+
+func TestCookies(t *testing.T) {
+	columns := []queryColumn{{Name: "x", Type: "bigint", TypeSignature: typeSignature{RawType: "bigint"}}}
+	ts := newPagedServer(
+		queryResponse{Columns: columns, Data: []queryData{{json.Number("1")}}},
+		queryResponse{Columns: columns, Data: []queryData{{json.Number("2")}}},
+	)
+	var cookies []string
+	ts.Config.Handler = func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				http.SetCookie(w, &http.Cookie{Name: "route", Value: "node-2"})
+			} else {
+				cookies = append(cookies, r.Header.Get("Cookie"))
+			}
+			h.ServeHTTP(w, r)
+		})
+	}(ts.Config.Handler)
+	defer ts.Close()
+	for _, tc := range []struct {
+		params string
+		want   []string
+	}{
+		{"", []string{"", ""}},
+		{"?cookies=true", []string{"route=node-2", "route=node-2"}},
+	} {
+		cookies = nil
+		db, err := sql.Open("presto", ts.URL+tc.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := db.Query("SELECT x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+		}
+		rows.Close()
+		db.Close()
+		if !reflect.DeepEqual(cookies, tc.want) {
+			t.Errorf("%q: want cookies %q while polling, got %q", tc.params, tc.want, cookies)
+		}
+	}
+}
+
+type countingTransport struct {
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestWithHTTPClient(t *testing.T) {
+	columns := []queryColumn{{Name: "x", Type: "bigint", TypeSignature: typeSignature{RawType: "bigint"}}}
+	ts := newPagedServer(
+		queryResponse{Columns: columns, Data: []queryData{{json.Number("1")}}},
+		queryResponse{Columns: columns, Data: []queryData{{json.Number("2")}}},
+	)
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	transport := &countingTransport{}
+	client := &http.Client{Transport: transport}
+	for _, ctx := range []context.Context{
+		context.Background(),
+		WithHTTPClient(context.Background(), client),
+	} {
+		rows, err := db.QueryContext(ctx, "SELECT x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+		}
+		rows.Close()
+	}
+	if transport.requests != 3 {
+		t.Fatalf("want the 3 requests of one query sent with the context client, got %d", transport.requests)
+	}
+}
+
+func TestWithRawBytes(t *testing.T) {
+	columns := []queryColumn{
+		{Name: "s", Type: "varchar", TypeSignature: typeSignature{RawType: "varchar"}},
+		{Name: "b", Type: "varbinary", TypeSignature: typeSignature{RawType: "varbinary"}},
+		{Name: "n", Type: "bigint", TypeSignature: typeSignature{RawType: "bigint"}},
+	}
+	ts := newPagedServer(queryResponse{
+		Columns: columns,
+		Data: []queryData{
+			{"abc", "AQID", json.Number("1")},
+			{"de", nil, json.Number("2")},
+		},
+	})
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(WithRawBytes(context.Background()), "SELECT s, b, n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var s, b sql.RawBytes
+		var n int64
+		if err := rows.Scan(&s, &b, &n); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s %v %v %d", s, []byte(b), b == nil, n))
+	}
+	want := []string{"abc [1 2 3] false 1", "de [] true 2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+
+	rows, err = db.QueryContext(WithRawBytes(context.Background()), "SELECT s, b, n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var s string
+	var b, n interface{}
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+	if err := rows.Scan(&s, &b, &n); err != nil {
+		t.Fatal(err)
+	}
+	if s != "abc" || !reflect.DeepEqual(b, []byte{1, 2, 3}) || n != int64(1) {
+		t.Fatalf("unexpected values %q, %v, %v", s, b, n)
+	}
+}
+
+func TestWithColumnDecoders(t *testing.T) {
+	columns := []queryColumn{
+		{Name: "ts", Type: "timestamp", TypeSignature: typeSignature{RawType: "timestamp"}},
+		{Name: "doc", Type: "varchar", TypeSignature: typeSignature{RawType: "varchar"}},
+		{Name: "n", Type: "bigint", TypeSignature: typeSignature{RawType: "bigint"}},
+	}
+	ts := newPagedServer(queryResponse{
+		Columns: columns,
+		Data:    []queryData{{"2017-07-10 01:02:03.000", `{"a":[1,2]}`, json.Number("1")}},
+	})
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := WithColumnDecoders(context.Background(), map[string]driver.ValueConverter{
+		"ts": RawDecoder,
+		"doc": DecoderFunc(func(v interface{}) (driver.Value, error) {
+			var doc map[string]interface{}
+			if err := json.Unmarshal([]byte(v.(string)), &doc); err != nil {
+				return nil, err
+			}
+			return doc, nil
+		}),
+	})
+	rows, err := db.QueryContext(ctx, "SELECT ts, doc, n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatal(rows.Err())
+	}
+	var tsv string
+	var doc, n interface{}
+	if err := rows.Scan(&tsv, &doc, &n); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"a": []interface{}{1.0, 2.0}}
+	if tsv != "2017-07-10 01:02:03.000" || !reflect.DeepEqual(doc, want) || n != int64(1) {
+		t.Fatalf("unexpected values %q, %v, %v", tsv, doc, n)
+	}
+}
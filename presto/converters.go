@@ -2,10 +2,33 @@ package presto
 
 import (
 	"database/sql/driver"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"strconv"
+	"time"
 )
 
+// converterConfig holds the settings shared by the column converters of a
+// query.
+type converterConfig struct {
+	// loc is the location of date, time and timestamp values without a
+	// time zone.
+	loc *time.Location
+	// target, if set, is the location all temporal values are converted
+	// to.
+	target *time.Location
+	// sized delivers tinyint, smallint and integer values as int8, int16
+	// and int32.
+	sized bool
+	// numbers is the mode numbers are delivered in, by class.
+	numbers map[string]string
+	// typedMapKeys delivers maps with numeric and boolean keys with keys
+	// of the matching Go type, at any depth.
+	typedMapKeys bool
+}
+
 type rowConverter struct {
 	fields     []string
 	converters []driver.ValueConverter
@@ -46,9 +69,309 @@ func (c *rowConverter) ConvertValue(v any) (driver.Value, error) {
 	return res, nil
 }
 
-func newComplexConverter(ts typeSignature) (driver.ValueConverter, error) {
+// arrayConverter converts the elements of array columns whose elements need
+// a conversion, such as arrays of temporal values.
+type arrayConverter struct {
+	elem driver.ValueConverter
+}
+
+// ConvertValue implements driver.ValueConverter interface to provide
+// conversion for array column types. The resulting value will be a []any
+// of converted elements.
+func (c *arrayConverter) ConvertValue(v any) (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	vs, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("presto: array converter needs []any and received %T", v)
+	}
+	res := make([]any, len(vs))
+	for i := range vs {
+		if vs[i] == nil {
+			continue
+		}
+		e, err := c.elem.ConvertValue(vs[i])
+		if err != nil {
+			return nil, fmt.Errorf("presto: converting element of array: %w", err)
+		}
+		res[i] = e
+	}
+	return res, nil
+}
+
+// mapConverter delivers the values of maps with keys of a numeric or
+// boolean type as maps with keys of the matching Go type, rather than the
+// strings the keys are sent as, for queries run with WithTypedMapKeys:
+// map[int64]any for integer keys, map[float64]any for real and double keys,
+// map[bool]any for boolean keys. Maps with other keys keep string keys, and
+// only have their values converted.
+type mapConverter struct {
+	keyType string
+	elem    driver.ValueConverter // converter of the values, nil if none
+}
+
+// isTypedMapKey reports whether map keys of the raw type are delivered with
+// a Go type other than string with WithTypedMapKeys.
+func isTypedMapKey(rawType string) bool {
+	switch rawType {
+	case "tinyint", "smallint", "integer", "bigint", "real", "double", "boolean":
+		return true
+	}
+	return false
+}
+
+// hasTypedMapKeys reports whether the type signature is, or contains, a
+// map with keys delivered with a Go type other than string with
+// WithTypedMapKeys.
+func hasTypedMapKeys(ts typeSignature) bool {
+	switch ts.RawType {
+	case "map", "array", "row":
+	default:
+		return false
+	}
+	for i, ta := range ts.TypeArguments {
+		var ats typeSignature
+		if err := json.Unmarshal(ta, &ats); err != nil {
+			return false
+		}
+		if ts.RawType == "map" && i == 0 && isTypedMapKey(ats.RawType) || hasTypedMapKeys(ats) {
+			return true
+		}
+	}
+	return false
+}
+
+// newMapConverter returns a converter for maps of the type signature with
+// typed keys, at any depth of their values.
+func newMapConverter(ts typeSignature, cfg *converterConfig) (*mapConverter, error) {
+	if len(ts.TypeArguments) != 2 {
+		return nil, fmt.Errorf("presto: map converter needs 2 type arguments and received %d", len(ts.TypeArguments))
+	}
+	var kts, vts typeSignature
+	if err := json.Unmarshal(ts.TypeArguments[0], &kts); err != nil {
+		return nil, fmt.Errorf("presto: parsing key type for map converter: %w", err)
+	}
+	if err := json.Unmarshal(ts.TypeArguments[1], &vts); err != nil {
+		return nil, fmt.Errorf("presto: parsing value type for map converter: %w", err)
+	}
+	c := &mapConverter{keyType: kts.RawType}
+	if hasTypedMapKeys(vts) {
+		elem, err := newComplexConverter(vts, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("presto: creating value converter for map converter: %w", err)
+		}
+		c.elem = elem
+	}
+	return c, nil
+}
+
+// ConvertValue implements driver.ValueConverter interface.
+func (c *mapConverter) ConvertValue(v any) (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("presto: map converter needs map[string]any and received %T", v)
+	}
+	value := func(e any) (any, error) {
+		if c.elem == nil || e == nil {
+			return e, nil
+		}
+		e, err := c.elem.ConvertValue(e)
+		if err != nil {
+			return nil, fmt.Errorf("presto: converting value of map: %w", err)
+		}
+		return e, nil
+	}
+	switch c.keyType {
+	case "boolean":
+		res := make(map[bool]any, len(m))
+		for k, e := range m {
+			b, err := strconv.ParseBool(k)
+			if err != nil {
+				return nil, fmt.Errorf("presto: converting map key %q to bool", k)
+			}
+			if res[b], err = value(e); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	case "real", "double":
+		res := make(map[float64]any, len(m))
+		for k, e := range m {
+			f, err := strconv.ParseFloat(k, 64)
+			if err != nil {
+				return nil, fmt.Errorf("presto: converting map key %q to float64", k)
+			}
+			if res[f], err = value(e); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	case "tinyint", "smallint", "integer", "bigint":
+		res := make(map[int64]any, len(m))
+		for k, e := range m {
+			n, err := strconv.ParseInt(k, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("presto: converting map key %q to int64", k)
+			}
+			if res[n], err = value(e); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	default:
+		res := make(map[string]any, len(m))
+		for k, e := range m {
+			var err error
+			if res[k], err = value(e); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+}
+
+// scanType returns the Go type of the maps delivered by the converter.
+func (c *mapConverter) scanType() reflect.Type {
+	switch c.keyType {
+	case "boolean":
+		return reflect.TypeOf(map[bool]any{})
+	case "real", "double":
+		return reflect.TypeOf(map[float64]any{})
+	case "tinyint", "smallint", "integer", "bigint":
+		return reflect.TypeOf(map[int64]any{})
+	}
+	return reflect.TypeOf(map[string]any{})
+}
+
+// DecoderFunc adapts a function to a driver.ValueConverter, to use it as a
+// column decoder with WithColumnDecoders.
+type DecoderFunc func(v interface{}) (driver.Value, error)
+
+// ConvertValue implements driver.ValueConverter interface.
+func (f DecoderFunc) ConvertValue(v interface{}) (driver.Value, error) {
+	return f(v)
+}
+
+// RawDecoder is a column decoder delivering values as strings holding their
+// representation in the presto protocol, like all values of the queries run
+// with WithRawValues.
+var RawDecoder driver.ValueConverter = rawConverter{}
+
+// rawConverter delivers values as strings of their representation in the
+// presto protocol, for queries run with WithRawValues.
+type rawConverter struct{}
+
+// ConvertValue implements driver.ValueConverter interface.
+func (rawConverter) ConvertValue(v any) (driver.Value, error) {
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return x, nil
+	case json.Number:
+		return x.String(), nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("presto: raw converter: %w", err)
+	}
+	return string(b), nil
+}
+
+// bytesConverter delivers string and binary values as []byte appended to a
+// buffer reset for every row, for queries run with WithRawBytes.
+type bytesConverter struct {
+	buf    *[]byte
+	binary bool
+}
+
+// isBytesType reports whether values of the raw type are delivered by a
+// bytesConverter for queries run with WithRawBytes.
+func isBytesType(rawType string) bool {
+	switch rawType {
+	case "char", "varchar", "json", "varbinary":
+		return true
+	}
+	return false
+}
+
+// ConvertValue implements driver.ValueConverter interface.
+func (c bytesConverter) ConvertValue(v any) (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("presto: bytes converter needs string and received %T", v)
+	}
+	b := *c.buf
+	start := len(b)
+	if c.binary {
+		n := base64.StdEncoding.DecodedLen(len(s))
+		if cap(b)-start < n {
+			b = append(make([]byte, 0, 2*cap(b)+n), b...)
+		}
+		n, err := base64.StdEncoding.Decode(b[start:start+n], []byte(s))
+		if err != nil {
+			return nil, fmt.Errorf("presto: decoding varbinary: %w", err)
+		}
+		b = b[:start+n]
+	} else {
+		b = append(b, s...)
+	}
+	*c.buf = b
+	// The capacity is capped so that appending to the value doesn't
+	// overwrite the next one.
+	return b[start:len(b):len(b)], nil
+}
+
+// isTemporal reports whether the type signature is a temporal type, or an
+// array of them.
+func isTemporal(ts typeSignature) bool {
+	switch ts.RawType {
+	case "date", "time", "time with time zone", "timestamp", "timestamp with time zone":
+		return true
+	case "array":
+		if len(ts.TypeArguments) != 1 {
+			return false
+		}
+		var ets typeSignature
+		if err := json.Unmarshal(ts.TypeArguments[0], &ets); err != nil {
+			return false
+		}
+		return isTemporal(ets)
+	}
+	return false
+}
+
+func newComplexConverter(ts typeSignature, cfg *converterConfig) (driver.ValueConverter, error) {
+	if ts.RawType == "array" && (isTemporal(ts) || cfg.typedMapKeys && hasTypedMapKeys(ts)) {
+		var ets typeSignature
+		if err := json.Unmarshal(ts.TypeArguments[0], &ets); err != nil {
+			return nil, fmt.Errorf("presto: parsing element type for array converter: %w", err)
+		}
+		elem, err := newComplexConverter(ets, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("presto: creating element converter for array converter: %w", err)
+		}
+		return &arrayConverter{elem: elem}, nil
+	}
+	if ts.RawType == "map" && cfg.typedMapKeys && hasTypedMapKeys(ts) {
+		return newMapConverter(ts, cfg)
+	}
 	if ts.RawType != "row" {
-		return newTypeConverter(ts.RawType), nil
+		c := newTypeConverter(ts.RawType)
+		c.loc = cfg.loc
+		c.target = cfg.target
+		c.sized = cfg.sized
+		c.numbers = cfg.numbers[numberClass(c.baseType)]
+		return c, nil
 	}
 
 	var c rowConverter
@@ -66,7 +389,7 @@ func newComplexConverter(ts typeSignature) (driver.ValueConverter, error) {
 		if err := json.Unmarshal(tas, &fts); err != nil {
 			return nil, fmt.Errorf("presto: parsing field type for row converter: %w", err)
 		}
-		conv, err := newComplexConverter(fts)
+		conv, err := newComplexConverter(fts, cfg)
 		if err != nil {
 			return nil, fmt.Errorf("presto: creating nested converted for row converter: %w", err)
 		}
@@ -0,0 +1,103 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// Queries are submitted to one of the configured coordinators; the pages of
+// results are fetched from the URIs returned by the coordinator that runs
+// the query, so only the submission has to choose a host.
+
+const (
+	// queryAffinity submits every query to the next coordinator.
+	queryAffinity = "query"
+
+	// connectionAffinity submits all queries of a connection to the
+	// coordinator picked when the connection was opened.
+	connectionAffinity = "connection"
+)
+
+// nextCoordinator distributes connections and queries across coordinators.
+var nextCoordinator uint32
+
+// parseCoordinators returns the base URLs of the coordinator of the DSN and
+// of the additional coordinators, given as a comma separated list of hosts.
+// The path of the DSN, e.g. /presto for a gateway mounting the coordinators
+// under a subpath, prefixes the API paths on every coordinator.
+func parseCoordinators(prestoURL *url.URL, hosts string) ([]string, error) {
+	prefix := strings.TrimRight(prestoURL.EscapedPath(), "/")
+	coordinators := []string{prestoURL.Scheme + "://" + prestoURL.Host + prefix}
+	if hosts == "" {
+		return coordinators, nil
+	}
+	for _, host := range strings.Split(hosts, ",") {
+		host = strings.TrimSpace(host)
+		if strings.ContainsAny(host, "/?#@") {
+			return nil, &ErrInvalidDSN{Param: "coordinators", Value: hosts}
+		}
+		if err := validateHost(host); err != nil {
+			return nil, &ErrInvalidDSN{Param: "coordinators", Value: hosts, Reason: err.Error()}
+		}
+		coordinators = append(coordinators, prestoURL.Scheme+"://"+host+prefix)
+	}
+	return coordinators, nil
+}
+
+// coordinatorURL returns the base URL of the coordinator to submit a query
+// to: the one of the context's affinity key if any, the one of the
+// connection if it is pinned or runs a transaction, or the next one.
+func (c *Conn) coordinatorURL(ctx context.Context) string {
+	// Lookups of the driver target a given coordinator.
+	if baseURL, ok := ctx.Value(coordinatorKey).(string); ok {
+		return baseURL
+	}
+	n := len(c.coordinators)
+	if n <= 1 {
+		return c.baseURL
+	}
+	if key, ok := ctx.Value(coordinatorAffinityKey).(string); ok {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		return c.coordinators[h.Sum32()%uint32(n)]
+	}
+	if c.affinity == connectionAffinity || c.inTransaction(ctx) {
+		return c.baseURL
+	}
+	return c.coordinators[atomic.AddUint32(&nextCoordinator, 1)%uint32(n)]
+}
+
+// nextCoordinatorURL returns the base URL of the coordinator following the
+// one at baseURL.
+func (c *Conn) nextCoordinatorURL(baseURL string) string {
+	for i, u := range c.coordinators {
+		if u == baseURL {
+			return c.coordinators[(i+1)%len(c.coordinators)]
+		}
+	}
+	return c.coordinators[0]
+}
+
+// isGatewayError reports whether a response status indicates that a gateway
+// in front of the coordinator could not reach it.
+func isGatewayError(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusGatewayTimeout
+}
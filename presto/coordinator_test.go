@@ -0,0 +1,160 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCoordinatorAffinity(t *testing.T) {
+	var headers1, headers2 []http.Header
+	ts1 := newHeaderRecorder(&headers1)
+	defer ts1.Close()
+	ts2 := newHeaderRecorder(&headers2)
+	defer ts2.Close()
+	dsn := ts1.URL + "?coordinators=" + strings.TrimPrefix(ts2.URL, "http://")
+
+	query := func(ctx context.Context, t *testing.T, db *sql.DB, n int) (int, int) {
+		headers1, headers2 = nil, nil
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		for i := 0; i < n; i++ {
+			rows, err := conn.QueryContext(ctx, "SELECT 1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows.Close()
+		}
+		return len(headers1), len(headers2)
+	}
+
+	t.Run("query", func(t *testing.T) {
+		db, err := sql.Open("presto", dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if n1, n2 := query(context.Background(), t, db, 4); n1 != 2 || n2 != 2 {
+			t.Fatalf("want queries distributed across coordinators, got %d and %d", n1, n2)
+		}
+		ctx := WithCoordinatorAffinity(context.Background(), "session")
+		if n1, n2 := query(ctx, t, db, 4); n1 != 4 && n2 != 4 {
+			t.Fatalf("want queries pinned by context, got %d and %d", n1, n2)
+		}
+	})
+
+	t.Run("connection", func(t *testing.T) {
+		db, err := sql.Open("presto", dsn+"&coordinator_affinity=connection")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if n1, n2 := query(context.Background(), t, db, 4); n1 != 4 && n2 != 4 {
+			t.Fatalf("want queries pinned to the connection, got %d and %d", n1, n2)
+		}
+	})
+}
+
+func TestPathPrefix(t *testing.T) {
+	ts := newPagedServer(queryResponse{})
+	var paths []string
+	ts.Config.Handler = func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths = append(paths, r.Method+" "+r.URL.Path)
+			h.ServeHTTP(w, r)
+		})
+	}(ts.Config.Handler)
+	defer ts.Close()
+
+	for _, prefix := range []string{"/presto", "/presto/"} {
+		paths = nil
+		db, err := sql.Open("presto", ts.URL+prefix+"?catalog=hive")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := db.Query("SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+		db.Close()
+		// The absolute URI of the results is fetched as returned.
+		want := []string{"POST /presto/v1/statement", "GET /v1/statement/test_query/0"}
+		if strings.Join(paths, ", ") != strings.Join(want, ", ") {
+			t.Errorf("%s: want requests %q, got %q", prefix, want, paths)
+		}
+	}
+}
+
+func TestInvalidCoordinators(t *testing.T) {
+	for _, dsn := range []string{
+		"http://localhost:8080?coordinators=host1:8080,,host2:8080",
+		"http://localhost:8080?coordinators=http://host1:8080",
+		"http://localhost:8080?coordinator_affinity=host",
+	} {
+		if _, err := newConn(dsn); err == nil {
+			t.Errorf("invalid dsn accepted: %s", dsn)
+		}
+	}
+}
+
+func TestSubmissionFailover(t *testing.T) {
+	var headers []http.Header
+	ts1 := newHeaderRecorder(&headers)
+	defer ts1.Close()
+	failures := 0
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failures++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts2.Close()
+	db, err := sql.Open("presto", ts1.URL+"?coordinators="+strings.TrimPrefix(ts2.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	before := Stats()
+	for i := 0; i < 2; i++ {
+		rows, err := db.Query("SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+	if len(headers) != 2 || failures != 1 {
+		t.Fatalf("want 2 queries and 1 failed submission, got %d and %d", len(headers), failures)
+	}
+	if n := Stats().Failovers - before.Failovers; n != 1 {
+		t.Fatalf("want 1 failover, got %d", n)
+	}
+
+	db, err = sql.Open("presto", ts2.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Query("SELECT 1")
+	if qf, ok := err.(*ErrQueryFailed); !ok || qf.StatusCode != http.StatusBadGateway {
+		t.Fatal("unexpected error:", err)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// loggedHeaders are the headers whose values are written to the debug
+// log, which are known to hold neither credentials nor SQL text. The values
+// of all other headers, such as Authorization, the extra credentials, the
+// headers set by SignRequest and the prepared statements, are redacted. The
+// headers of the Trino protocol are logged like their presto names.
+var loggedHeaders = map[string]bool{
+	"Accept":                       true,
+	"Accept-Encoding":              true,
+	"Cache-Control":                true,
+	"Connection":                   true,
+	"Content-Encoding":             true,
+	"Content-Length":               true,
+	"Content-Type":                 true,
+	"Date":                         true,
+	"Retry-After":                  true,
+	"Server":                       true,
+	"Transfer-Encoding":            true,
+	"User-Agent":                   true,
+	"Vary":                         true,
+	requestIDHeader:                true,
+	prestoUserHeader:               true,
+	prestoSourceHeader:             true,
+	prestoCatalogHeader:            true,
+	prestoSchemaHeader:             true,
+	prestoSetCatalogHeader:         true,
+	prestoSetSchemaHeader:          true,
+	prestoSessionHeader:            true,
+	prestoTransactionHeader:        true,
+	prestoStartedTransactionHeader: true,
+	prestoClearTransactionHeader:   true,
+	prestoClientTagsHeader:         true,
+	prestoClientInfoHeader:         true,
+	prestoQueryDataEncodingHeader:  true,
+	prestoTraceTokenHeader:         true,
+	prestoClientCapabilitiesHeader: true,
+	prestoResourceEstimateHeader:   true,
+	prestoTimeZoneHeader:           true,
+	prestoLanguageHeader:           true,
+	prestoRoleHeader:               true,
+	prestoSetRoleHeader:            true,
+}
+
+// isLoggedHeader reports whether the value of a header is written to the
+// debug log.
+func isLoggedHeader(k string) bool {
+	if strings.HasPrefix(k, trinoHeaderPrefix) {
+		k = prestoHeaderPrefix + k[len(trinoHeaderPrefix):]
+	}
+	return loggedHeaders[k]
+}
+
+// requestIDHeader carries a unique ID for every HTTP request, to correlate
+// each hop of a query in proxy and server logs.
+const requestIDHeader = "X-Request-Id"
+
+// do sends the request with client under a new request ID, reporting it to
+// the request hooks with the attempt number and the host that served it,
+// and logging the exchange to the debug writer of the connection if any.
+func (c *Conn) do(client *http.Client, req *http.Request, attempt int) (*http.Response, error) {
+	id := newRequestID()
+	req.Header.Set(requestIDHeader, id)
+	if c.trino {
+		req.Header = renameHeaders(req.Header, prestoHeaderPrefix, trinoHeaderPrefix)
+	}
+	e := RequestEnd{RequestID: id, Method: req.Method, URL: redactURL(req.URL), Attempt: attempt}
+	// The last connection is the one of the final response, after any
+	// redirects.
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			e.RemoteAddr = info.Conn.RemoteAddr().String()
+			e.Reused = info.Reused
+		},
+	}))
+	start := time.Now()
+	var resp *http.Response
+	err := c.sign(req)
+	if err == nil {
+		resp, err = client.Do(req)
+	}
+	elapsed := time.Since(start)
+	if c.trino && resp != nil {
+		resp.Header = renameHeaders(resp.Header, trinoHeaderPrefix, prestoHeaderPrefix)
+	}
+
+	e.Duration = elapsed
+	e.Err = err
+	if resp != nil {
+		e.StatusCode = resp.StatusCode
+	}
+	endRequest(e)
+	if c.debug != nil {
+		c.logExchange(req, resp, err, elapsed.Round(time.Millisecond))
+	}
+	return resp, err
+}
+
+// Page is a response of the client protocol, as passed to page observers.
+type Page struct {
+	// URL is the URL of the request, with any password and query parameter
+	// values redacted.
+	URL        string
+	StatusCode int
+	Header     http.Header
+	// Body is the raw JSON document of the response.
+	Body []byte
+}
+
+// observePage passes a protocol response to the page observers of ctx and
+// of the connection.
+func (c *Conn) observePage(ctx context.Context, resp *http.Response, body []byte) {
+	observe, _ := ctx.Value(pageObserverKey).(func(Page))
+	if observe == nil && c.pageObserver == nil {
+		return
+	}
+	p := Page{URL: redactURL(resp.Request.URL), StatusCode: resp.StatusCode, Header: resp.Header, Body: body}
+	if observe != nil {
+		observe(p)
+	}
+	if c.pageObserver != nil {
+		c.pageObserver(p)
+	}
+}
+
+// redactURL returns u with its password and the values of its query
+// parameters redacted, such as the signatures and tokens of pre-signed
+// spooled segment URLs.
+func redactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.Redacted()
+	}
+	r := *u
+	q := r.Query()
+	for k := range q {
+		q[k] = []string{"REDACTED"}
+	}
+	r.RawQuery = q.Encode()
+	return r.Redacted()
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// logExchange writes an HTTP exchange to the debug writer.
+func (c *Conn) logExchange(req *http.Request, resp *http.Response, err error, elapsed time.Duration) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "presto: > %s %s (%s)\n", req.Method, redactURL(req.URL), bodySize(req.ContentLength))
+	writeDebugHeaders(&b, "presto: >   ", req.Header)
+	if err != nil {
+		fmt.Fprintf(&b, "presto: < error after %v: %v\n", elapsed, err)
+	} else {
+		fmt.Fprintf(&b, "presto: < %s in %v (%s)\n", resp.Status, elapsed, bodySize(resp.ContentLength))
+		writeDebugHeaders(&b, "presto: <   ", resp.Header)
+	}
+	// A single write keeps the lines of concurrent exchanges together.
+	c.debug.Write(b.Bytes())
+}
+
+func writeDebugHeaders(b *bytes.Buffer, prefix string, h http.Header) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			if !isLoggedHeader(k) {
+				v = "[REDACTED]"
+			}
+			fmt.Fprintf(b, "%s%s: %s\n", prefix, k, v)
+		}
+	}
+}
+
+func bodySize(n int64) string {
+	if n < 0 {
+		return "unknown size"
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
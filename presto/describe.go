@@ -0,0 +1,250 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxInputTypes is the number of statements whose parameter types are
+// cached by a connection.
+const maxInputTypes = 256
+
+// ErrArgumentType indicates that an argument of a query can't be converted
+// to the type of its parameter, as described by DESCRIBE INPUT. Position
+// starts at 1.
+type ErrArgumentType struct {
+	Position int
+	Type     string
+	Value    interface{}
+	Reason   string
+}
+
+// Error implements the error interface.
+func (e *ErrArgumentType) Error() string {
+	msg := fmt.Sprintf("presto: cannot use %T %v as argument %d of type %s", e.Value, e.Value, e.Position, e.Type)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// inputTypes returns the types of the parameters of the statement, as
+// described by DESCRIBE INPUT with the headers of the query, cached by
+// statement, catalog and schema on the connection, since the same statement
+// may refer to other tables after a USE statement.
+func (c *Conn) inputTypes(ctx context.Context, text string, hs http.Header) ([]string, error) {
+	hs = contextHeaders(ctx, hs.Clone())
+	catalog, schema := hs.Get(prestoCatalogHeader), hs.Get(prestoSchemaHeader)
+	if catalog == "" {
+		catalog = c.Catalog()
+	}
+	if schema == "" {
+		schema = c.Schema()
+	}
+	key := catalog + "\x00" + schema + "\x00" + text
+	if types, ok := c.inputTypeCache[key]; ok {
+		return types, nil
+	}
+	hs.Set(preparedStatementHeader, c.preparedStatementName+"="+url.QueryEscape(text))
+	query := "DESCRIBE INPUT " + c.preparedStatementName
+	rows, err := (&driverStmt{conn: c, query: query}).submit(ctx, query, hs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.cancel()
+	var types []string
+	dest := make([]driver.Value, 2)
+	for {
+		err := rows.Next(dest)
+		var eof *EOF
+		if err == io.EOF || errors.As(err, &eof) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		typ, _ := dest[1].(string)
+		types = append(types, typ)
+	}
+	if c.inputTypeCache == nil || len(c.inputTypeCache) >= maxInputTypes {
+		c.inputTypeCache = make(map[string][]string)
+	}
+	c.inputTypeCache[key] = types
+	return types, nil
+}
+
+// coerceArgs returns the arguments as literals of the types of the
+// parameters of the statement.
+func coerceArgs(values []interface{}, types []string) ([]string, error) {
+	if len(values) != len(types) {
+		return nil, fmt.Errorf("presto: statement has %d parameters, got %d arguments", len(types), len(values))
+	}
+	ss := make([]string, len(values))
+	for i, v := range values {
+		s, err := coerceArg(v, types[i])
+		if err != nil {
+			var at *ErrArgumentType
+			if errors.As(err, &at) {
+				at.Position = i + 1
+			}
+			return nil, err
+		}
+		ss[i] = s
+	}
+	return ss, nil
+}
+
+// coerceArg returns the argument as a literal of the type, e.g. an integer
+// as BIGINT '1' or a string as DATE '2024-01-31'.
+func coerceArg(v interface{}, typ string) (string, error) {
+	if v == nil {
+		return "CAST(NULL AS " + typ + ")", nil
+	}
+	fail := func(reason string) (string, error) {
+		return "", &ErrArgumentType{Type: typ, Value: v, Reason: reason}
+	}
+	base := typ
+	if i := strings.IndexByte(typ, '('); i >= 0 {
+		base = typ[:i]
+	}
+	switch base {
+	case "tinyint", "smallint", "integer", "bigint":
+		var n int64
+		switch x := v.(type) {
+		case int64:
+			n = x
+		case string:
+			var err error
+			if n, err = strconv.ParseInt(x, 10, 64); err != nil {
+				return fail("not an integer")
+			}
+		default:
+			return fail("")
+		}
+		bits := map[string]uint{"tinyint": 8, "smallint": 16, "integer": 32, "bigint": 64}[base]
+		if limit := int64(1) << (bits - 1); bits < 64 && (n < -limit || n >= limit) {
+			return fail("out of range")
+		}
+		return strings.ToUpper(base) + " '" + strconv.FormatInt(n, 10) + "'", nil
+	case "real", "double":
+		bitSize := 64
+		if base == "real" {
+			bitSize = 32
+		}
+		var f float64
+		switch x := v.(type) {
+		case int64:
+			f = float64(x)
+		case float64:
+			f = x
+		case string:
+			var err error
+			if f, err = strconv.ParseFloat(x, bitSize); err != nil {
+				return fail("not a number")
+			}
+		default:
+			return fail("")
+		}
+		s := strconv.FormatFloat(f, 'g', -1, bitSize)
+		if math.IsInf(f, 0) {
+			s = strings.TrimPrefix(strings.Replace(s, "Inf", "Infinity", 1), "+")
+		}
+		return strings.ToUpper(base) + " '" + s + "'", nil
+	case "decimal":
+		var s string
+		switch x := v.(type) {
+		case int64:
+			s = strconv.FormatInt(x, 10)
+		case float64:
+			if math.IsNaN(x) || math.IsInf(x, 0) {
+				return fail("not a finite number")
+			}
+			s = strconv.FormatFloat(x, 'f', -1, 64)
+		case string:
+			if _, err := strconv.ParseFloat(x, 64); err != nil {
+				return fail("not a number")
+			}
+			s = x
+		default:
+			return fail("")
+		}
+		return "DECIMAL '" + s + "'", nil
+	case "boolean":
+		switch x := v.(type) {
+		case bool:
+			return strconv.FormatBool(x), nil
+		case string:
+			b, err := strconv.ParseBool(x)
+			if err != nil {
+				return fail("not a boolean")
+			}
+			return strconv.FormatBool(b), nil
+		}
+		return fail("")
+	case "varchar", "char", "json":
+		switch x := v.(type) {
+		case string:
+			return Serial(x)
+		case []byte:
+			return Serial(string(x))
+		}
+		return fail("")
+	case "varbinary":
+		switch x := v.(type) {
+		case []byte:
+			return "X'" + hex.EncodeToString(x) + "'", nil
+		case string:
+			return "X'" + hex.EncodeToString([]byte(x)) + "'", nil
+		}
+		return fail("")
+	case "date":
+		switch x := v.(type) {
+		case time.Time:
+			return "DATE '" + x.Format("2006-01-02") + "'", nil
+		case string:
+			if _, err := time.Parse("2006-01-02", x); err != nil {
+				return fail("not a date as YYYY-MM-DD")
+			}
+			return "DATE '" + x + "'", nil
+		}
+		return fail("")
+	case "timestamp", "timestamp with time zone":
+		layout := "2006-01-02 15:04:05.000"
+		if base != "timestamp" {
+			layout += " -07:00"
+		}
+		switch x := v.(type) {
+		case time.Time:
+			return "TIMESTAMP '" + x.Format(layout) + "'", nil
+		case string:
+			lit, err := Serial(x)
+			return "TIMESTAMP " + lit, err
+		}
+		return fail("")
+	}
+	return Serial(v)
+}
@@ -0,0 +1,182 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDescribeInput(t *testing.T) {
+	var describes int
+	var executed []string
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodPost:
+			b, _ := io.ReadAll(r.Body)
+			next := "/v1/statement/query/0"
+			if strings.HasPrefix(string(b), "DESCRIBE INPUT ") {
+				describes++
+				next = "/v1/statement/describe/0"
+			} else if string(b) == "USE hive.web" {
+				w.Header().Set(prestoSetCatalogHeader, "hive")
+				w.Header().Set(prestoSetSchemaHeader, "web")
+			} else {
+				executed = append(executed, string(b))
+			}
+			json.NewEncoder(w).Encode(&stmtResponse{ID: "test_query", NextURI: ts.URL + next})
+		case strings.Contains(r.URL.Path, "/describe/"):
+			json.NewEncoder(w).Encode(&queryResponse{
+				ID: "test_query",
+				Columns: []queryColumn{
+					{Name: "Position", Type: "bigint", TypeSignature: typeSignature{RawType: "bigint"}},
+					{Name: "Type", Type: "varchar", TypeSignature: typeSignature{RawType: "varchar"}},
+				},
+				Data: []queryData{
+					{json.Number("0"), "integer"},
+					{json.Number("1"), "date"},
+					{json.Number("2"), "double"},
+				},
+				Stats: stmtStats{State: "FINISHED"},
+			})
+		default:
+			json.NewEncoder(w).Encode(&queryResponse{ID: "test_query", Stats: stmtStats{State: "FINISHED"}})
+		}
+	}))
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL+"?describe_input=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	const query = "SELECT * FROM t WHERE a = ? AND b = ? AND c > ?"
+	for i := 0; i < 2; i++ {
+		rows, err := db.Query(query, 5, "2024-01-31", 1.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+	if describes != 1 {
+		t.Errorf("want the parameters described once, got %d", describes)
+	}
+	if len(executed) != 2 || !strings.HasSuffix(executed[0], " USING INTEGER '5', DATE '2024-01-31', DOUBLE '1.5'") {
+		t.Fatalf("want coerced arguments, got %q", executed)
+	}
+
+	_, err = db.Query(query, int64(3000000000), "2024-01-31", 1.5)
+	var at *ErrArgumentType
+	if !errors.As(err, &at) || at.Position != 1 || at.Type != "integer" {
+		t.Fatal("want argument type error for argument 1, got", err)
+	}
+	want := "presto: cannot use int64 3000000000 as argument 1 of type integer: out of range"
+	if err.Error() != want {
+		t.Errorf("want error %q, got %q", want, err)
+	}
+	if _, err = db.Query(query, 5, "2024-01-31"); err == nil || !strings.Contains(err.Error(), "has 3 parameters, got 2 arguments") {
+		t.Fatal("want argument count error, got", err)
+	}
+	if len(executed) != 2 {
+		t.Fatalf("want failed coercions not executed, got %q", executed)
+	}
+
+	for _, tc := range []struct {
+		ctx       context.Context
+		describes int
+	}{
+		{WithCatalogSchema(context.Background(), "tpch", "sf1"), 2},
+		{WithCatalogSchema(context.Background(), "tpch", "sf1"), 2},
+		{context.Background(), 2},
+	} {
+		rows, err := db.QueryContext(tc.ctx, query, 5, "2024-01-31", 1.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+		if describes != tc.describes {
+			t.Fatalf("want the parameters described %d times, got %d", tc.describes, describes)
+		}
+	}
+	if _, err := db.Exec("USE hive.web"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		rows, err := db.Query(query, 5, "2024-01-31", 1.5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+	if describes != 3 {
+		t.Errorf("want the parameters described again after USE, got %d describes", describes)
+	}
+}
+
+func TestCoerceArg(t *testing.T) {
+	ts := time.Date(2024, 1, 31, 12, 30, 0, 0, time.FixedZone("", -5*3600))
+	for _, tc := range []struct {
+		value interface{}
+		typ   string
+		want  string
+	}{
+		{int64(1), "bigint", "BIGINT '1'"},
+		{"42", "smallint", "SMALLINT '42'"},
+		{int64(-128), "tinyint", "TINYINT '-128'"},
+		{int64(128), "tinyint", ""},
+		{1.5, "integer", ""},
+		{0.1, "real", "REAL '0.1'"},
+		{int64(2), "double", "DOUBLE '2'"},
+		{math.Inf(-1), "double", "DOUBLE '-Infinity'"},
+		{"12.50", "decimal(10,2)", "DECIMAL '12.50'"},
+		{1.25, "decimal(10,2)", "DECIMAL '1.25'"},
+		{"abc", "decimal(10,2)", ""},
+		{"true", "boolean", "true"},
+		{"it's", "varchar(10)", "'it''s'"},
+		{int64(1), "varchar", ""},
+		{[]byte{0xca, 0xfe}, "varbinary", "X'cafe'"},
+		{ts, "date", "DATE '2024-01-31'"},
+		{"2024-31-01", "date", ""},
+		{ts, "timestamp", "TIMESTAMP '2024-01-31 12:30:00.000'"},
+		{ts, "timestamp with time zone", "TIMESTAMP '2024-01-31 12:30:00.000 -05:00'"},
+		{nil, "array(integer)", "CAST(NULL AS array(integer))"},
+		{"x", "ipaddress", "'x'"},
+	} {
+		got, err := coerceArg(tc.value, tc.typ)
+		if tc.want == "" {
+			var at *ErrArgumentType
+			if !errors.As(err, &at) {
+				t.Errorf("%v as %s: want argument type error, got %q, %v", tc.value, tc.typ, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%v as %s: want %q, got %q, %v", tc.value, tc.typ, tc.want, got, err)
+		}
+	}
+}
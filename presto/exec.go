@@ -0,0 +1,100 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+)
+
+// ExecResult is the result of a statement run with Exec, e.g. DDL or DML,
+// which reports what the statement did. database/sql wraps the results of
+// drivers, so it is only available with WithExecResult, or as the result
+// of (*Conn).ExecContext through (*sql.Conn).Raw.
+type ExecResult struct {
+	QueryID string
+	// UpdateType is the kind of statement presto ran, e.g. "CREATE TABLE"
+	// or "INSERT", empty for queries.
+	UpdateType string
+	// UpdateCount is the number of rows the statement changed, -1 if
+	// presto didn't report it, e.g. for DDL.
+	UpdateCount int64
+	// State is the final state of the query, FINISHED once it succeeded.
+	State string
+}
+
+var _ driver.Result = &ExecResult{}
+
+// LastInsertId implements the driver.Result interface. presto has no
+// auto-generated IDs, so it always fails.
+func (r *ExecResult) LastInsertId() (int64, error) {
+	return 0, ErrOperationNotSupported
+}
+
+// RowsAffected implements the driver.Result interface, with the update
+// count of the statement.
+func (r *ExecResult) RowsAffected() (int64, error) {
+	if r.UpdateCount < 0 {
+		return 0, errors.New("presto: statement reported no update count")
+	}
+	return r.UpdateCount, nil
+}
+
+// WithExecResult returns a copy of ctx whose statements run with Exec store
+// their result in res when they succeed, e.g. to check that a statement
+// was the INSERT it was meant to be:
+//
+//	var res presto.ExecResult
+//	_, err := db.ExecContext(presto.WithExecResult(ctx, &res), stmt)
+//	...
+//	if res.UpdateType != "INSERT" {
+func WithExecResult(ctx context.Context, res *ExecResult) context.Context {
+	return context.WithValue(ctx, execResultKey, res)
+}
+
+// ExecContext implements the driver.StmtExecContext interface. The
+// statement is run like a query, whose rows are discarded, until it ends.
+func (st *driverStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	rows, err := st.QueryContext(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	qr := rows.(*driverRows)
+	defer qr.Close()
+	if qr.columns == nil && qr.nextURI != "" {
+		qr.Columns()
+	}
+	dest := make([]driver.Value, len(qr.columns))
+	for {
+		err := qr.Next(dest)
+		var eof *EOF
+		if err == io.EOF || errors.As(err, &eof) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	res := &ExecResult{QueryID: qr.id, UpdateType: qr.updateType, UpdateCount: -1, State: qr.stats.State}
+	if qr.updateCount != nil {
+		res.UpdateCount = *qr.updateCount
+	}
+	if dst, _ := ctx.Value(execResultKey).(*ExecResult); dst != nil {
+		*dst = *res
+	}
+	return res, nil
+}
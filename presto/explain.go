@@ -0,0 +1,204 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// IOPlan is the plan of the inputs and output of a query, as planned by
+// EXPLAIN (TYPE IO).
+type IOPlan struct {
+	Inputs   []IOInput  `json:"inputTableColumnInfos"`
+	Output   *IOTable   `json:"outputTable"`
+	Estimate IOEstimate `json:"estimate"`
+}
+
+// IOInput is a table read by a query, with the constraints on its columns.
+// The constraints on the partition columns of a table select the partitions
+// that are read.
+type IOInput struct {
+	Table       IOTable              `json:"table"`
+	Constraints []IOColumnConstraint `json:"columnConstraints"`
+	Estimate    IOEstimate           `json:"estimate"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface.
+func (in *IOInput) UnmarshalJSON(b []byte) error {
+	type input IOInput
+	v := input{Estimate: unknownEstimate()}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*in = IOInput(v)
+	return nil
+}
+
+// IOTable is a fully qualified table name.
+type IOTable struct {
+	Catalog string
+	Schema  string
+	Table   string
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface.
+func (t *IOTable) UnmarshalJSON(b []byte) error {
+	var v struct {
+		Catalog     string `json:"catalog"`
+		SchemaTable struct {
+			Schema string `json:"schema"`
+			Table  string `json:"table"`
+		} `json:"schemaTable"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*t = IOTable{Catalog: v.Catalog, Schema: v.SchemaTable.Schema, Table: v.SchemaTable.Table}
+	return nil
+}
+
+// String returns the table name as catalog.schema.table.
+func (t IOTable) String() string {
+	return t.Catalog + "." + t.Schema + "." + t.Table
+}
+
+// IOColumnConstraint is the domain of the values read from a column.
+type IOColumnConstraint struct {
+	Column string   `json:"columnName"`
+	Type   string   `json:"typeSignature"`
+	Domain IODomain `json:"domain"`
+}
+
+// IODomain is a set of values, as ranges of values and whether nulls are
+// part of it.
+type IODomain struct {
+	NullsAllowed bool      `json:"nullsAllowed"`
+	Ranges       []IORange `json:"ranges"`
+}
+
+// IORange is a range of values, a single value when both its bounds are
+// the same exact value.
+type IORange struct {
+	Low  IOMarker `json:"low"`
+	High IOMarker `json:"high"`
+}
+
+// IOMarker is a bound of a range, with the value formatted as a string. Its
+// value is nil for unbounded ranges, and its bound is one of ABOVE, EXACTLY
+// or BELOW.
+type IOMarker struct {
+	Value *string `json:"value"`
+	Bound string  `json:"bound"`
+}
+
+// IOEstimate holds the estimates of the optimizer for a query or one of its
+// inputs. Estimates the optimizer has no statistics for are NaN.
+type IOEstimate struct {
+	OutputRows  float64
+	OutputBytes float64
+	CPUCost     float64
+	MaxMemory   float64
+	NetworkCost float64
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface.
+func (e *IOEstimate) UnmarshalJSON(b []byte) error {
+	var v struct {
+		OutputRowCount    json.RawMessage `json:"outputRowCount"`
+		OutputSizeInBytes json.RawMessage `json:"outputSizeInBytes"`
+		CPUCost           json.RawMessage `json:"cpuCost"`
+		MaxMemory         json.RawMessage `json:"maxMemory"`
+		NetworkCost       json.RawMessage `json:"networkCost"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	var err error
+	estimate := func(b json.RawMessage) float64 {
+		if err != nil {
+			return math.NaN()
+		}
+		var f float64
+		f, err = parseEstimate(b)
+		return f
+	}
+	*e = IOEstimate{
+		OutputRows:  estimate(v.OutputRowCount),
+		OutputBytes: estimate(v.OutputSizeInBytes),
+		CPUCost:     estimate(v.CPUCost),
+		MaxMemory:   estimate(v.MaxMemory),
+		NetworkCost: estimate(v.NetworkCost),
+	}
+	return err
+}
+
+// parseEstimate parses an estimate, which presto sends as a string when it
+// is not finite, and omits when the plan has none.
+func parseEstimate(b json.RawMessage) (float64, error) {
+	if len(b) == 0 || string(b) == "null" {
+		return math.NaN(), nil
+	}
+	s := string(b)
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return 0, err
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("presto: invalid estimate %s", b)
+	}
+	return f, nil
+}
+
+// EstimateIO returns the tables, partitions and estimated size of what
+// query reads and writes, as planned by EXPLAIN (TYPE IO, FORMAT JSON)
+// without running the query, e.g. for schedulers that refuse or
+// reprioritize expensive queries.
+func EstimateIO(ctx context.Context, q Queryer, query string, args ...interface{}) (*IOPlan, error) {
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	rows, err := q.QueryContext(ctx, "EXPLAIN (TYPE IO, FORMAT JSON) "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		var eof *EOF
+		if err := rows.Err(); err != nil && !errors.As(err, &eof) {
+			return nil, err
+		}
+		return nil, errors.New("presto: no IO plan for query")
+	}
+	var plan string
+	if err := rows.Scan(&plan); err != nil {
+		return nil, err
+	}
+	p := &IOPlan{Estimate: unknownEstimate()}
+	if err := json.Unmarshal([]byte(plan), p); err != nil {
+		return nil, fmt.Errorf("presto: invalid IO plan: %v", err)
+	}
+	return p, nil
+}
+
+func unknownEstimate() IOEstimate {
+	nan := math.NaN()
+	return IOEstimate{OutputRows: nan, OutputBytes: nan, CPUCost: nan, MaxMemory: nan, NetworkCost: nan}
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"math"
+	"net/http"
+	"testing"
+)
+
+func TestEstimateIO(t *testing.T) {
+	plan := `{
+  "inputTableColumnInfos" : [ {
+    "table" : {"catalog" : "hive", "schemaTable" : {"schema" : "web", "table" : "events"}},
+    "columnConstraints" : [ {
+      "columnName" : "ds",
+      "typeSignature" : "varchar",
+      "domain" : {"nullsAllowed" : false, "ranges" : [ {
+        "low" : {"value" : "2024-01-01", "bound" : "EXACTLY"},
+        "high" : {"value" : "2024-01-01", "bound" : "EXACTLY"}
+      } ]}
+    } ],
+    "estimate" : {"outputRowCount" : 1000.0, "outputSizeInBytes" : 64000.0, "cpuCost" : 64000.0, "maxMemory" : 0.0, "networkCost" : 0.0}
+  }, {
+    "table" : {"catalog" : "hive", "schemaTable" : {"schema" : "web", "table" : "users"}},
+    "columnConstraints" : [ ]
+  } ],
+  "outputTable" : {"catalog" : "hive", "schemaTable" : {"schema" : "web", "table" : "report"}},
+  "estimate" : {"outputRowCount" : "NaN", "outputSizeInBytes" : "NaN", "cpuCost" : "Infinity", "maxMemory" : 0.0, "networkCost" : "NaN"}
+}`
+	columns := []queryColumn{{Name: "Query Plan", Type: "varchar", TypeSignature: typeSignature{RawType: "varchar"}}}
+	ts := newPagedServer(queryResponse{Columns: columns, Data: []queryData{{plan}}, Stats: stmtStats{State: "FINISHED"}})
+	var statements []string
+	ts.Config.Handler = func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				b, _ := io.ReadAll(r.Body)
+				statements = append(statements, string(b))
+			}
+			h.ServeHTTP(w, r)
+		})
+	}(ts.Config.Handler)
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	p, err := EstimateIO(context.Background(), db, "INSERT INTO report SELECT * FROM events JOIN users USING (id);")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "EXPLAIN (TYPE IO, FORMAT JSON) INSERT INTO report SELECT * FROM events JOIN users USING (id)"; len(statements) != 1 || statements[0] != want {
+		t.Fatalf("want statement %q, got %q", want, statements)
+	}
+	if len(p.Inputs) != 2 || p.Inputs[0].Table.String() != "hive.web.events" || p.Output == nil || p.Output.Table != "report" {
+		t.Fatalf("unexpected tables: %+v", p)
+	}
+	c := p.Inputs[0].Constraints
+	if len(c) != 1 || c[0].Column != "ds" || len(c[0].Domain.Ranges) != 1 || *c[0].Domain.Ranges[0].Low.Value != "2024-01-01" {
+		t.Fatalf("unexpected constraints: %+v", c)
+	}
+	if e := p.Inputs[0].Estimate; e.OutputRows != 1000 || e.OutputBytes != 64000 {
+		t.Fatalf("unexpected input estimate: %+v", e)
+	}
+	if e := p.Inputs[1].Estimate; !math.IsNaN(e.OutputRows) || !math.IsNaN(e.OutputBytes) {
+		t.Fatalf("want unknown estimate for input without one, got %+v", e)
+	}
+	if e := p.Estimate; !math.IsNaN(e.OutputRows) || !math.IsInf(e.CPUCost, 1) || e.MaxMemory != 0 {
+		t.Fatalf("unexpected query estimate: %+v", e)
+	}
+}
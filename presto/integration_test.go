@@ -38,10 +38,11 @@ var (
 	)
 )
 
-func init() {
+func TestMain(m *testing.M) {
 	flag.Parse()
 	DefaultQueryTimeout = *integrationServerQueryTimeout
 	DefaultCancelQueryTimeout = *integrationServerQueryTimeout
+	os.Exit(m.Run())
 }
 
 // integrationServerDSN returns the URL of the integration test server.
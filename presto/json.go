@@ -0,0 +1,230 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"reflect"
+)
+
+// The Null types marshal to JSON as their value, or null if not valid, so
+// that scanned rows can be serialized as they are, e.g. in API responses.
+// Elements of slices that are null marshal to null too, and non-finite
+// floating point numbers, which JSON lacks, to the strings "NaN",
+// "Infinity" and "-Infinity", as in the presto protocol.
+
+// marshalNull returns the JSON encoding of v, or null if not valid.
+func marshalNull(valid bool, v interface{}) ([]byte, error) {
+	if !valid {
+		return []byte("null"), nil
+	}
+	return json.Marshal(jsonValue(reflect.ValueOf(v)))
+}
+
+// jsonValue returns the value to marshal for v, replacing the nullable
+// values of the database/sql package and of this package by their value or
+// nil.
+func jsonValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	switch x := v.Interface().(type) {
+	case sql.NullBool:
+		if !x.Valid {
+			return nil
+		}
+		return x.Bool
+	case sql.NullString:
+		if !x.Valid {
+			return nil
+		}
+		return x.String
+	case sql.NullInt64:
+		if !x.Valid {
+			return nil
+		}
+		return x.Int64
+	case sql.NullFloat64:
+		if !x.Valid {
+			return nil
+		}
+		return jsonFloat(x.Float64)
+	case float64:
+		return jsonFloat(x)
+	case float32:
+		return jsonFloat(float64(x))
+	case NullTime:
+		if !x.Valid {
+			return nil
+		}
+		return x.Time
+	case NullMap:
+		if !x.Valid {
+			return nil
+		}
+		return x.Map
+	}
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
+		if v.IsNil() {
+			return nil
+		}
+		res := make([]interface{}, v.Len())
+		for i := range res {
+			res[i] = jsonValue(v.Index(i))
+		}
+		return res
+	}
+	return v.Interface()
+}
+
+// jsonFloat returns f, or its name if it is not finite.
+func jsonFloat(f float64) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	}
+	return f
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullSliceBool) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.SliceBool)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullSlice2Bool) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.Slice2Bool)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullSlice3Bool) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.Slice3Bool)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullSliceString) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.SliceString)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullSlice2String) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.Slice2String)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullSlice3String) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.Slice3String)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullSliceInt64) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.SliceInt64)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullSlice2Int64) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.Slice2Int64)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullSlice3Int64) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.Slice3Int64)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullInt8) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.Int8)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullInt16) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.Int16)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullFloat32) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.Float32)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullSliceFloat64) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.SliceFloat64)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullSlice2Float64) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.Slice2Float64)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullSlice3Float64) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.Slice3Float64)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullTime) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.Time)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullSliceTime) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.SliceTime)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullSlice2Time) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.Slice2Time)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullSlice3Time) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.Slice3Time)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullSliceJSON) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.SliceJSON)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullBingTile) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.BingTile)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullMap) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.Map)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullSliceMap) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.SliceMap)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullSlice2Map) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.Slice2Map)
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s NullSlice3Map) MarshalJSON() ([]byte, error) {
+	return marshalNull(s.Valid, s.Slice3Map)
+}
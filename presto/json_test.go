@@ -0,0 +1,61 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNullMarshalJSON(t *testing.T) {
+	ts := time.Date(2017, 7, 10, 1, 2, 3, 0, time.UTC)
+	scenarios := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{"null", NullSliceString{}, `null`},
+		{"null pointer", &NullSliceInt64{}, `null`},
+		{"slice", NullSliceString{SliceString: []sql.NullString{{String: "a", Valid: true}, {}}, Valid: true}, `["a",null]`},
+		{"slice2", NullSlice2Bool{Slice2Bool: [][]sql.NullBool{{{Bool: true, Valid: true}}, nil}, Valid: true}, `[[true],null]`},
+		{"slice3", &NullSlice3Int64{Slice3Int64: [][][]sql.NullInt64{{{{Int64: 1, Valid: true}}}}, Valid: true}, `[[[1]]]`},
+		{"floats", NullSliceFloat64{SliceFloat64: []sql.NullFloat64{{Float64: 1.5, Valid: true}, {Float64: math.NaN(), Valid: true}, {Float64: math.Inf(-1), Valid: true}}, Valid: true}, `[1.5,"NaN","-Infinity"]`},
+		{"float32", NullFloat32{Float32: float32(math.Inf(1)), Valid: true}, `"Infinity"`},
+		{"int8", NullInt8{Int8: -3, Valid: true}, `-3`},
+		{"time", NullTime{Time: ts, Valid: true}, `"2017-07-10T01:02:03Z"`},
+		{"slice time", NullSliceTime{SliceTime: []NullTime{{Time: ts, Valid: true}, {}}, Valid: true}, `["2017-07-10T01:02:03Z",null]`},
+		{"slice json", NullSliceJSON{SliceJSON: []json.RawMessage{json.RawMessage(`{"a":[1]}`), nil}, Valid: true}, `[{"a":[1]},null]`},
+		{"map", NullMap{Map: map[string]interface{}{"a": json.Number("1")}, Valid: true}, `{"a":1}`},
+		{"slice map", NullSliceMap{SliceMap: []NullMap{{}, {Map: map[string]interface{}{"b": "x"}, Valid: true}}, Valid: true}, `[null,{"b":"x"}]`},
+		{"struct", struct {
+			Tags NullSliceString
+			Day  NullTime
+		}{Day: NullTime{Time: ts, Valid: true}}, `{"Tags":null,"Day":"2017-07-10T01:02:03Z"}`},
+	}
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			b, err := json.Marshal(scenario.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != scenario.expected {
+				t.Fatalf("unexpected JSON:\nhave %s\nwant %s", b, scenario.expected)
+			}
+		})
+	}
+}
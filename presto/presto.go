@@ -51,26 +51,38 @@
 package presto
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"database/sql"
 	"database/sql/driver"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math"
+	"mime"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
 	"os"
+	osuser "os/user"
+	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode"
+	"unicode/utf8"
 
 	"gopkg.in/jcmturner/gokrb5.v6/client"
 	"gopkg.in/jcmturner/gokrb5.v6/config"
@@ -88,26 +100,58 @@ var (
 	// DefaultCancelQueryTimeout is the timeout for the request to cancel queries in presto.
 	DefaultCancelQueryTimeout = 30 * time.Second
 
+	// DefaultEmptyPageMaxBackoff is the maximum wait between polls for
+	// results while a query keeps returning empty pages in the same state,
+	// e.g. while it is queued or planned, once a minimum wait is set.
+	DefaultEmptyPageMaxBackoff = time.Second
+
+	// DefaultResourceRetryBackoff is the initial wait before resubmitting
+	// a query that failed because the cluster ran out of resources.
+	DefaultResourceRetryBackoff = 10 * time.Second
+
 	// ErrOperationNotSupported indicates that a database operation is not supported.
 	ErrOperationNotSupported = errors.New("presto: operation not supported")
 
 	// ErrQueryCancelled indicates that a query has been cancelled.
 	ErrQueryCancelled = errors.New("presto: query cancelled")
+
+	// ErrNoDeadline indicates that a query was not run because its context
+	// has no deadline, as required by the connection.
+	ErrNoDeadline = errors.New("presto: query context has no deadline")
+)
+
+// Client capabilities advertised to presto with Config.ClientCapabilities.
+const (
+	// CapabilityPath lets the server return the PATH type signature.
+	CapabilityPath = "PATH"
+
+	// CapabilityParametricDatetime lets the server return time and
+	// timestamp types with their precision, e.g. timestamp(6).
+	CapabilityParametricDatetime = "PARAMETRIC_DATETIME"
 )
 
 const (
 	preparedStatementHeader        = "X-Presto-Prepared-Statement"
-	preparedStatementName          = "_presto_go"
+	preparedStatementPrefix        = "_presto_go"
+	clientName                     = "presto-go-client"
 	prestoUserHeader               = "X-Presto-User"
 	prestoSourceHeader             = "X-Presto-Source"
 	prestoCatalogHeader            = "X-Presto-Catalog"
 	prestoSchemaHeader             = "X-Presto-Schema"
+	prestoSetCatalogHeader         = "X-Presto-Set-Catalog"
+	prestoSetSchemaHeader          = "X-Presto-Set-Schema"
 	prestoSessionHeader            = "X-Presto-Session"
 	prestoTransactionHeader        = "X-Presto-Transaction-Id"
 	prestoStartedTransactionHeader = "X-Presto-Started-Transaction-Id"
 	prestoClearTransactionHeader   = "X-Presto-Clear-Transaction-Id"
 	prestoClientTagsHeader         = "X-Presto-Client-Tags"
 	prestoClientInfoHeader         = "X-Presto-Client-Info"
+	prestoQueryDataEncodingHeader  = "X-Presto-Query-Data-Encoding"
+	prestoTraceTokenHeader         = "X-Presto-Trace-Token"
+	prestoClientCapabilitiesHeader = "X-Presto-Client-Capabilities"
+	prestoResourceEstimateHeader   = "X-Presto-Resource-Estimate"
+	prestoTimeZoneHeader           = "X-Presto-Time-Zone"
+	prestoLanguageHeader           = "X-Presto-Language"
 
 	kerberosEnabledConfig    = "KerberosEnabled"
 	kerberosKeytabPathConfig = "KerberosKeytabPath"
@@ -115,33 +159,224 @@ const (
 	kerberosRealmConfig      = "KerberosRealm"
 	kerberosConfigPathConfig = "KerberosConfigPath"
 	sSLCertPathConfig        = "SSLCertPath"
+	sSLClientCertPathConfig  = "SSLClientCertPath"
+	sSLClientKeyPathConfig   = "SSLClientKeyPath"
 
 	accessTokenConfig = "AccessToken"
+
+	legacyTimestampConfig = "legacy_timestamp"
+
+	defaultMaxRedirects = 10
 )
 
-type sqldriver struct{}
+type sqldriver struct {
+	// trino speaks the dialect of the protocol of Trino servers.
+	trino bool
+}
 
 func (d *sqldriver) Open(name string) (driver.Conn, error) {
-	return newConn(name)
+	conn, err := newConn(name)
+	if err != nil {
+		return nil, err
+	}
+	conn.trino = d.trino
+	return conn, nil
+}
+
+// OpenConnector implements the driver.DriverContext interface.
+func (d *sqldriver) OpenConnector(name string) (driver.Connector, error) {
+	if _, err := parseDSN(name); err != nil {
+		return nil, err
+	}
+	return &connector{dsn: name, trino: d.trino}, nil
+}
+
+var (
+	_ driver.Driver        = &sqldriver{}
+	_ driver.DriverContext = &sqldriver{}
+)
+
+type connector struct {
+	dsn            string
+	trino          bool
+	traceTokenFunc func(context.Context) string
+	pageObserver   func(Page)
+	signRequest    func(*http.Request, string) error
+	tokenProvider  TokenProvider
+	transport      http.RoundTripper
+	debug          io.Writer
+
+	// queue is shared by the connections, created with the first one.
+	queueOnce sync.Once
+	queue     *clientQueue
+
+	// transport is shared by the connections, so they share a pool of
+	// HTTP connections: the one of TLSConfig, or else of the first one.
+	transportOnce sync.Once
+}
+
+// NewConnector returns a connector for sql.OpenDB, which unlike a DSN string
+// supports the options of Config that can't be encoded in a DSN, such as
+// TraceTokenFunc.
+func NewConnector(c *Config) (driver.Connector, error) {
+	dsn, err := c.FormatDSN()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parseDSN(dsn); err != nil {
+		return nil, err
+	}
+	var transport http.RoundTripper
+	if c.TLSConfig != nil {
+		if c.CustomClientName != "" {
+			return nil, fmt.Errorf("presto: client configuration error, TLSConfig can't be combined with CustomClientName")
+		}
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = c.TLSConfig.Clone()
+		transport = t
+	}
+	return &connector{
+		dsn:            dsn,
+		traceTokenFunc: c.TraceTokenFunc,
+		pageObserver:   c.PageObserver,
+		signRequest:    c.SignRequest,
+		tokenProvider:  c.TokenProvider,
+		transport:      transport,
+		debug:          c.DebugWriter,
+	}, nil
+}
+
+// Connect implements the driver.Connector interface.
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := newConn(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	conn.trino = c.trino
+	conn.traceTokenFunc = c.traceTokenFunc
+	conn.pageObserver = c.pageObserver
+	conn.signRequest = c.signRequest
+	conn.tokenProvider = c.tokenProvider
+	c.transportOnce.Do(func() {
+		if c.transport == nil {
+			c.transport = conn.httpClient.Transport
+		}
+	})
+	if c.transport != nil {
+		conn.httpClient.Transport = c.transport
+	}
+	c.queueOnce.Do(func() { c.queue = conn.queue })
+	conn.queue = c.queue
+	if c.debug != nil {
+		conn.debug = c.debug
+	}
+	return conn, nil
 }
 
-var _ driver.Driver = &sqldriver{}
+// Driver implements the driver.Connector interface.
+func (c *connector) Driver() driver.Driver {
+	return &sqldriver{trino: c.trino}
+}
 
-// Config is a configuration that can be encoded to a DSN string.
+// Config is a configuration that can be encoded to a DSN string. Its
+// TraceTokenFunc, PageObserver, SignRequest, TLSConfig, TokenProvider and
+// DebugWriter can't be encoded in a DSN, and only apply to databases opened
+// with NewConnector.
 type Config struct {
-	PrestoURI          string            // URI of the Presto server, e.g. http://user@localhost:8080
-	Source             string            // Source of the connection (optional)
-	Catalog            string            // Catalog (optional)
-	Schema             string            // Schema (optional)
-	SessionProperties  map[string]string // Session properties (optional)
-	CustomClientName   string            // Custom client name (optional)
-	KerberosEnabled    string            // KerberosEnabled (optional, default is false)
-	KerberosKeytabPath string            // Kerberos Keytab Path (optional)
-	KerberosPrincipal  string            // Kerberos Principal used to authenticate to KDC (optional)
-	KerberosRealm      string            // The Kerberos Realm (optional)
-	KerberosConfigPath string            // The krb5 config path (optional)
-	SSLCertPath        string            // The SSL cert path for TLS verification (optional)
-	AccessToken        string            // The JWT access token for authentication (optional)
+	PrestoURI               string            // URI of the Presto server, e.g. http://user@localhost:8080
+	User                    string            // User if PrestoURI has none (optional, default is the user running the process)
+	Password                string            // Password for HTTP Basic authentication, which requires https unless InsecureBasicAuth is set (optional)
+	InsecureBasicAuth       bool              // Allow HTTP Basic authentication over plain http, e.g. behind a TLS-terminating sidecar (optional)
+	Source                  string            // Source of the connection (optional)
+	ClientTags              []string          // Tags of the queries of the connection, matched by the selectors of resource groups (optional)
+	OnBehalfOf              []string          // Delegation chain of the queries, from the end user they run as to the last service before User, which authenticates and must be allowed to impersonate the end user (optional)
+	TraceToken              string            // Trace token of the queries of the connection without one from TraceTokenFunc or WithTraceToken (optional)
+	SourceSuffix            string            // Suffix of the source identifying the application, e.g. "myapp/1.2" (optional)
+	UserAgent               string            // Product of the application prepended to the HTTP User-Agent, e.g. "myapp/1.2" (optional)
+	Cookies                 bool              // Keep the cookies set by load balancers for sticky routing (optional)
+	StrictDecoding          bool              // Fail on protocol responses with unexpected shapes, to diagnose proxies and servers (optional)
+	ExecuteImmediate        bool              // Send queries with arguments as EXECUTE IMMEDIATE statements, falling back on older servers (optional)
+	DescribeInput           bool              // Convert the arguments of queries to the types of their parameters, as described by DESCRIBE INPUT (optional)
+	SizedIntegers           bool              // Deliver tinyint, smallint and integer values as int8, int16 and int32 rather than int64 (optional)
+	Catalog                 string            // Catalog (optional)
+	Schema                  string            // Schema (optional)
+	SessionProperties       map[string]string // Session properties, catalog.property for catalog properties (optional)
+	Roles                   map[string]string // Initial roles by catalog, a role name or "ALL" or "NONE", changed by SET ROLE statements (optional)
+	QueryAnnotations        map[string]string // Annotations prepended to every statement in a comment, e.g. app, version and owner (optional)
+	NumberDecoding          map[string]string // Go type of the numbers of the bigint, double and decimal classes: "float64", "number" for json.Number or "string" (optional)
+	CustomClientName        string            // Custom client name (optional)
+	KerberosEnabled         string            // KerberosEnabled (optional, default is false)
+	KerberosKeytabPath      string            // Kerberos Keytab Path (optional)
+	KerberosPrincipal       string            // Kerberos Principal used to authenticate to KDC (optional)
+	KerberosRealm           string            // The Kerberos Realm (optional)
+	KerberosConfigPath      string            // The krb5 config path (optional)
+	SSLCertPath             string            // The SSL cert path for TLS verification (optional)
+	SSLClientCertPath       string            // The PEM client certificate path for mutual TLS, with SSLClientKeyPath (optional)
+	SSLClientKeyPath        string            // The PEM client key path for mutual TLS, with SSLClientCertPath (optional)
+	AccessToken             string            // The JWT access token for authentication (optional)
+	DisableRedirects        bool              // Do not follow 307/308 redirects from gateways (optional)
+	MaxRedirects            int               // Maximum number of redirects followed per request (optional, default is 10)
+	MaxQueuedTime           time.Duration     // Cancel queries queued for longer than this (optional)
+	QueuedRetries           int               // Number of resubmissions of queries cancelled after MaxQueuedTime (optional)
+	LegacyTimestamp         string            // Legacy timestamp semantics, "true" or "false" (optional, default is the server setting)
+	Language                string            // Session language as a BCP 47 tag, e.g. "de-CH", for locale-sensitive functions (optional, default is the server locale)
+	TimeZone                string            // Session time zone, e.g. "America/New_York" or "+05:30", also the location of legacy temporal values (optional, default is the server time zone)
+	QueryDataEncoding       string            // Encoding of spooled results, only "json" is supported (optional)
+	CompressThreshold       int               // Gzip statements of at least this many bytes on submission (optional, default is no compression)
+	MaxStatementSize        int               // Maximum size of statements in bytes, larger ones fail before submission (optional)
+	QueueFullRetries        int               // Number of resubmissions of queries rejected because server queues are full (optional)
+	ClientQueueSize         int               // Maximum number of rejected queries held for resubmission at once (optional, default is unbounded)
+	Coordinators            []string          // Additional coordinators as host:port, queries are distributed across them and PrestoURI (optional)
+	Affinity                string            // Coordinator affinity, "query" or "connection" (optional, default is "query")
+	ClientCapabilities      []string          // Protocol features supported by the client, e.g. CapabilityPath (optional)
+	EmptyPageMinBackoff     time.Duration     // Initial wait between polls returning empty pages in the same state (optional, default is to poll without waiting)
+	EmptyPageMaxBackoff     time.Duration     // Maximum wait between polls returning empty pages in the same state (optional, default is DefaultEmptyPageMaxBackoff)
+	TransactionID           string            // ID of an existing transaction all queries run in, started elsewhere (optional)
+	PreparedStatementPrefix string            // Prefix of the name of the prepared statements of the connections (optional, default is "_presto_go")
+	ResourceRetries         int               // Number of resubmissions of queries failing for lack of resources before delivering rows (optional)
+	ResourceRetryBackoff    time.Duration     // Initial wait before resubmitting queries failing for lack of resources, doubled on every retry (optional, default is DefaultResourceRetryBackoff)
+	SubmissionRetries       int               // Number of resubmissions of queries whose submission failed without an answer, once checked that it did not create them (optional)
+	InListThreshold         int               // Rewrite IN lists of more values as subqueries on a VALUES relation (optional, default is no rewriting)
+	RequireDeadline         bool              // Reject queries whose context has no deadline with ErrNoDeadline (optional)
+	DefaultDeadline         time.Duration     // Timeout of queries whose context has no deadline, unless RequireDeadline is set (optional)
+	RetryBudget             int               // Maximum number of requests retried per query, on submission and page fetches, after 503 and 429 responses (optional, default is unlimited)
+	RetryBudgetTime         time.Duration     // Maximum time spent waiting to retry requests per query (optional, default is unlimited)
+
+	// TraceTokenFunc derives the trace token of every query from its
+	// context, e.g. from a request ID, unless the context has one set by
+	// WithTraceToken. Empty tokens are not sent.
+	TraceTokenFunc func(ctx context.Context) string
+
+	// PageObserver receives every response of the client protocol before
+	// it is decoded, like the observers set by WithPageObserver. It must
+	// be safe for concurrent use.
+	PageObserver func(Page)
+
+	// SignRequest is called with every HTTP request right before it is
+	// sent, and the hex encoded SHA-256 hash of its body, to sign it for
+	// authenticating proxies, e.g. with AWS Signature Version 4, by
+	// setting headers or query parameters. Errors fail the request. It
+	// must be safe for concurrent use.
+	SignRequest func(req *http.Request, bodyHash string) error
+
+	// TLSConfig is the TLS configuration of the connections to https
+	// coordinators, e.g. with client certificates held in memory for
+	// mutual TLS, replacing SSLCertPath and the client certificate paths.
+	// It can't be combined with CustomClientName. The connections of a
+	// connector share its transport.
+	TLSConfig *tls.Config
+
+	// TokenProvider is asked for the access token sent as a bearer token
+	// before every HTTP request, for short-lived tokens refreshed while
+	// the database is open, replacing AccessToken.
+	TokenProvider TokenProvider
+
+	// Debug logs every HTTP exchange with presto to standard error, with
+	// the method, URL, headers, status, timing and body sizes. Credentials
+	// are redacted. DebugWriter replaces standard error, and must be safe
+	// for concurrent use.
+	Debug       bool
+	DebugWriter io.Writer
 }
 
 // FormatDSN returns a DSN string from the configuration.
@@ -155,10 +390,26 @@ func (c *Config) FormatDSN() (string, error) {
 		for k, v := range c.SessionProperties {
 			sessionkv = append(sessionkv, k+"="+v)
 		}
+		sort.Strings(sessionkv)
+	}
+	var annotations []string
+	for k, v := range c.QueryAnnotations {
+		annotations = append(annotations, k+"="+v)
 	}
+	sort.Strings(annotations)
+	var numbers []string
+	for k, v := range c.NumberDecoding {
+		numbers = append(numbers, k+"="+v)
+	}
+	sort.Strings(numbers)
+	var roles []string
+	for k, v := range c.Roles {
+		roles = append(roles, k+"="+v)
+	}
+	sort.Strings(roles)
 	source := c.Source
 	if source == "" {
-		source = "presto-go-client"
+		source = clientName
 	}
 	query := make(url.Values)
 	query.Add("source", source)
@@ -170,6 +421,14 @@ func (c *Config) FormatDSN() (string, error) {
 		query.Add(sSLCertPathConfig, c.SSLCertPath)
 	}
 
+	if c.SSLClientCertPath != "" || c.SSLClientKeyPath != "" {
+		if !isSSL {
+			return "", fmt.Errorf("presto: client configuration error, SSL must be enabled for client certificates")
+		}
+		query.Add(sSLClientCertPathConfig, c.SSLClientCertPath)
+		query.Add(sSLClientKeyPathConfig, c.SSLClientKeyPath)
+	}
+
 	if KerberosEnabled {
 		query.Add(kerberosEnabledConfig, "true")
 		query.Add(kerberosKeytabPathConfig, c.KerberosKeytabPath)
@@ -185,11 +444,179 @@ func (c *Config) FormatDSN() (string, error) {
 		query.Add(accessTokenConfig, c.AccessToken)
 	}
 
+	if c.Password != "" {
+		user := c.User
+		if prestoURL.User != nil && prestoURL.User.Username() != "" {
+			user = prestoURL.User.Username()
+		}
+		if user == "" {
+			return "", fmt.Errorf("presto: client configuration error, Password requires a user")
+		}
+		prestoURL.User = url.UserPassword(user, c.Password)
+	}
+
+	if c.InsecureBasicAuth {
+		query.Add("insecure_basic_auth", "true")
+	} else if prestoURL.User != nil && !isSSL {
+		if _, ok := prestoURL.User.Password(); ok {
+			return "", fmt.Errorf("presto: client configuration error, SSL must be enabled for basic authentication")
+		}
+	}
+
+	if c.DisableRedirects {
+		query.Add("follow_redirects", "false")
+	}
+
+	if c.MaxRedirects > 0 {
+		query.Add("max_redirects", strconv.Itoa(c.MaxRedirects))
+	}
+
+	if c.MaxQueuedTime > 0 {
+		query.Add("max_queued_time", c.MaxQueuedTime.String())
+		if c.QueuedRetries > 0 {
+			query.Add("queued_retries", strconv.Itoa(c.QueuedRetries))
+		}
+	}
+
+	if c.CompressThreshold > 0 {
+		query.Add("compress_threshold", strconv.Itoa(c.CompressThreshold))
+	}
+
+	if c.Debug || c.DebugWriter != nil {
+		query.Add("debug", "true")
+	}
+
+	if c.Cookies {
+		query.Add("cookies", "true")
+	}
+
+	if c.StrictDecoding {
+		query.Add("strict_decoding", "true")
+	}
+
+	if c.ExecuteImmediate {
+		query.Add("execute_immediate", "true")
+	}
+
+	if c.DescribeInput {
+		query.Add("describe_input", "true")
+	}
+
+	if c.SizedIntegers {
+		query.Add("sized_integers", "true")
+	}
+
+	if len(c.Coordinators) > 0 {
+		query.Add("coordinators", strings.Join(c.Coordinators, ","))
+	}
+
+	if len(c.ClientCapabilities) > 0 {
+		query.Add("client_capabilities", strings.Join(c.ClientCapabilities, ","))
+	}
+
+	if len(c.ClientTags) > 0 {
+		for _, tag := range c.ClientTags {
+			if tag == "" || strings.Contains(tag, ",") {
+				return "", fmt.Errorf("presto: client configuration error, invalid client tag: %q", tag)
+			}
+		}
+		query.Add("client_tags", strings.Join(c.ClientTags, ","))
+	}
+
+	if len(c.OnBehalfOf) > 0 {
+		for _, p := range c.OnBehalfOf {
+			if p == "" || strings.Contains(p, ",") {
+				return "", fmt.Errorf("presto: client configuration error, invalid principal in OnBehalfOf: %q", p)
+			}
+		}
+		query.Add("on_behalf_of", strings.Join(c.OnBehalfOf, ","))
+	}
+
+	if c.Affinity != "" {
+		query.Add("coordinator_affinity", c.Affinity)
+	}
+
+	if c.QueueFullRetries > 0 {
+		query.Add("queue_full_retries", strconv.Itoa(c.QueueFullRetries))
+		if c.ClientQueueSize > 0 {
+			query.Add("client_queue_size", strconv.Itoa(c.ClientQueueSize))
+		}
+	}
+
+	if c.ResourceRetries > 0 {
+		query.Add("resource_retries", strconv.Itoa(c.ResourceRetries))
+		if c.ResourceRetryBackoff > 0 {
+			query.Add("resource_retry_backoff", c.ResourceRetryBackoff.String())
+		}
+	}
+
+	if c.SubmissionRetries > 0 {
+		query.Add("submission_retries", strconv.Itoa(c.SubmissionRetries))
+	}
+
+	if c.InListThreshold > 0 {
+		query.Add("in_list_threshold", strconv.Itoa(c.InListThreshold))
+	}
+
+	if c.RequireDeadline {
+		query.Add("require_deadline", "true")
+	}
+
+	if c.DefaultDeadline > 0 {
+		query.Add("default_deadline", c.DefaultDeadline.String())
+	}
+
+	if c.RetryBudget > 0 {
+		query.Add("retry_budget", strconv.Itoa(c.RetryBudget))
+	}
+
+	if c.RetryBudgetTime > 0 {
+		query.Add("retry_budget_time", c.RetryBudgetTime.String())
+	}
+
+	if c.MaxStatementSize > 0 {
+		query.Add("max_statement_size", strconv.Itoa(c.MaxStatementSize))
+	}
+
+	if c.EmptyPageMinBackoff > 0 {
+		query.Add("empty_page_min_backoff", c.EmptyPageMinBackoff.String())
+	}
+
+	if c.EmptyPageMaxBackoff > 0 {
+		query.Add("empty_page_max_backoff", c.EmptyPageMaxBackoff.String())
+	}
+
+	if c.TransactionID != "" {
+		query.Add("transaction_id", c.TransactionID)
+	}
+
+	if c.PreparedStatementPrefix != "" {
+		query.Add("prepared_statement_prefix", c.PreparedStatementPrefix)
+	}
+
+	if c.LegacyTimestamp != "" {
+		legacy, err := strconv.ParseBool(c.LegacyTimestamp)
+		if err != nil {
+			return "", fmt.Errorf("presto: client configuration error, invalid LegacyTimestamp: %q", c.LegacyTimestamp)
+		}
+		query.Add(legacyTimestampConfig, strconv.FormatBool(legacy))
+	}
+
 	for k, v := range map[string]string{
-		"catalog":            c.Catalog,
-		"schema":             c.Schema,
-		"session_properties": strings.Join(sessionkv, ","),
-		"custom_client":      c.CustomClientName,
+		"user":                c.User,
+		"catalog":             c.Catalog,
+		"schema":              c.Schema,
+		"session_properties":  strings.Join(sessionkv, ","),
+		"query_annotations":   strings.Join(annotations, ","),
+		"number_decoding":     strings.Join(numbers, ","),
+		"roles":               strings.Join(roles, ","),
+		"custom_client":       c.CustomClientName,
+		"source_suffix":       c.SourceSuffix,
+		"user_agent":          c.UserAgent,
+		"trace_token":         c.TraceToken,
+		"time_zone":           c.TimeZone,
+		"language":            c.Language,
+		"query_data_encoding": c.QueryDataEncoding,
 	} {
 		if v != "" {
 			query[k] = []string{v}
@@ -199,26 +626,304 @@ func (c *Config) FormatDSN() (string, error) {
 	return prestoURL.String(), nil
 }
 
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ExpandEnv returns the DSN template with every ${NAME} replaced by the
+// value of the environment variable NAME, so that the same template can be
+// shipped to all environments:
+//
+//	dsn, err := presto.ExpandEnv("https://${PRESTO_USER}@presto:8443?catalog=${PRESTO_CATALOG}")
+//	...
+//	db, err := sql.Open("presto", dsn)
+//
+// Values are escaped, so they may hold any character, but no part of the
+// URL structure, such as a host and port. Other dollar signs are kept. It
+// fails with an ErrInvalidDSN if a variable is not set.
+func ExpandEnv(template string) (string, error) {
+	var missing []string
+	dsn := envReference.ReplaceAllStringFunc(template, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		// Spaces are escaped as %20, which decodes to a space in the user
+		// information too, unlike a plus sign.
+		return strings.ReplaceAll(url.QueryEscape(v), "+", "%20")
+	})
+	if len(missing) > 0 {
+		return "", &ErrInvalidDSN{Reason: "environment variables not set: " + strings.Join(missing, ", ")}
+	}
+	return dsn, nil
+}
+
 // Conn is a presto connection.
 type Conn struct {
-	baseURL         string
-	auth            *url.Userinfo
-	httpClient      http.Client
-	httpHeaders     http.Header
-	kerberosClient  client.Client
-	kerberosEnabled bool
+	baseURL          string
+	coordinators     []string
+	affinity         string
+	auth             *url.Userinfo
+	httpClient       http.Client
+	httpHeaders      http.Header
+	kerberosClient   client.Client
+	kerberosEnabled  bool
+	maxRedirects     int
+	maxQueuedTime    time.Duration
+	queuedRetries    int
+	queueFullRetries int
+	queue            *clientQueue
+	resourceRetries  int
+	resourceBackoff  time.Duration
+	retryBudget      int
+	retryBudgetTime  time.Duration
+
+	// submissionRetries is the number of resubmissions of a query after
+	// ambiguous failures, once checked that they did not create it.
+	submissionRetries int
+
+	// inListThreshold is the number of values above which IN lists are
+	// rewritten as VALUES subqueries, zero if disabled.
+	inListThreshold int
+
+	// annotations is the body of the comment prepended to every statement,
+	// empty if disabled.
+	annotations string
+
+	// requireDeadline rejects queries whose context has no deadline, and
+	// defaultDeadline, if set, bounds them instead.
+	requireDeadline bool
+	defaultDeadline time.Duration
+
+	maxStatementSize int
+
+	// strictDecoding fails on protocol responses with unexpected fields.
+	strictDecoding bool
+
+	// sizedIntegers delivers integer values with the width of their type.
+	sizedIntegers bool
+
+	// numberDecoding is the mode numbers are delivered in, by class.
+	numberDecoding map[string]string
+
+	// roles are the roles of the connection by catalog, as selected
+	// roles, e.g. ROLE{admin}, set by the DSN and SET ROLE statements.
+	roles map[string]string
+
+	// executeImmediate sends queries with parameters as EXECUTE IMMEDIATE
+	// statements, until the server turns out not to support them.
+	executeImmediate bool
+
+	// describeInput converts the arguments of queries to the types of
+	// their parameters, cached by statement, catalog and schema in
+	// inputTypeCache.
+	describeInput  bool
+	inputTypeCache map[string][]string
+
+	// preparedStatementName is unique to the connection, so that several
+	// clients sharing a session through a gateway do not collide.
+	preparedStatementName string
+
+	// emptyPageMinBackoff and emptyPageMaxBackoff bound the wait between
+	// polls returning empty pages in the same state.
+	emptyPageMinBackoff time.Duration
+	emptyPageMaxBackoff time.Duration
+
+	// compressThreshold is the minimum size of statements sent gzip
+	// encoded, zero if compression is disabled or not accepted by the server.
+	compressThreshold int
+
+	// timestampLocation is the location of temporal values without a
+	// time zone, which depends on the legacy_timestamp semantics.
+	timestampLocation *time.Location
+
+	traceTokenFunc func(context.Context) string
+
+	// traceToken is the trace token of queries without one from their
+	// context or traceTokenFunc, empty if not set.
+	traceToken string
+
+	// pageObserver receives the protocol responses of all queries, nil if
+	// not set.
+	pageObserver func(Page)
+
+	// signRequest signs every request before it is sent, nil if not set.
+	signRequest func(*http.Request, string) error
+
+	// accessToken is the static bearer token of the DSN, empty if not set.
+	accessToken string
+
+	// tokenProvider provides the bearer token of every request, nil if
+	// not set.
+	tokenProvider TokenProvider
+
+	// debug receives the log of HTTP exchanges, nil if disabled.
+	debug io.Writer
+
+	// trino sends and receives the protocol headers with the Trino
+	// prefix, for connections of the trino driver.
+	trino bool
 }
 
 var (
 	_ driver.Conn               = &Conn{}
 	_ driver.ConnPrepareContext = &Conn{}
 	_ driver.ConnBeginTx        = &Conn{}
+	_ driver.QueryerContext     = &Conn{}
+	_ driver.ExecerContext      = &Conn{}
 )
 
-func newConn(dsn string) (*Conn, error) {
+// statementNamePrefix matches the prefixes of prepared statement names,
+// which must be unquoted identifiers.
+var statementNamePrefix = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// annotationKey matches the keys of query annotations.
+var annotationKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// sessionPropertyName matches the names of session properties, either
+// system properties or catalog properties prefixed with the catalog name,
+// e.g. hive.insert_existing_partitions_behavior.
+var sessionPropertyName = regexp.MustCompile(`^(?:[A-Za-z0-9_-]+\.)?[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseAnnotations returns the key=value annotations of a comma separated
+// list as the body of a SQL comment.
+func parseAnnotations(v string) (string, error) {
+	var kvs []string
+	for _, kv := range strings.Split(v, ",") {
+		k, val, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if !ok || !annotationKey.MatchString(k) || strings.Contains(val, "*/") || strings.ContainsAny(val, "\r\n") {
+			return "", &ErrInvalidDSN{Param: "query_annotations", Value: v}
+		}
+		kvs = append(kvs, k+"="+strings.TrimSpace(val))
+	}
+	return strings.Join(kvs, ", "), nil
+}
+
+// numberModes are the Go types numbers can be decoded to with the
+// number_decoding DSN parameter.
+var numberModes = map[string]reflect.Type{
+	"float64": reflect.TypeOf(float64(0)),
+	"number":  reflect.TypeOf(json.Number("")),
+	"string":  reflect.TypeOf(""),
+}
+
+// parseNumberDecoding returns the class=mode pairs of a comma separated
+// list, by number class.
+func parseNumberDecoding(v string) (map[string]string, error) {
+	modes := make(map[string]string)
+	for _, kv := range strings.Split(v, ",") {
+		class, mode, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		if _, ok := numberModes[mode]; !ok || (class != "bigint" && class != "double" && class != "decimal") {
+			return nil, &ErrInvalidDSN{Param: "number_decoding", Value: v}
+		}
+		modes[class] = mode
+	}
+	return modes, nil
+}
+
+// newTLSConfig returns the TLS configuration of the CA bundle and client
+// certificate of the DSN, nil if it has neither.
+func newTLSConfig(prestoQuery url.Values) (*tls.Config, error) {
+	certPath := prestoQuery.Get(sSLCertPathConfig)
+	clientCertPath := prestoQuery.Get(sSLClientCertPathConfig)
+	clientKeyPath := prestoQuery.Get(sSLClientKeyPathConfig)
+	if certPath == "" && clientCertPath == "" && clientKeyPath == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{}
+	if certPath != "" {
+		cert, err := os.ReadFile(certPath)
+		if err != nil {
+			return nil, fmt.Errorf("presto: Error loading SSL Cert File: %v", err)
+		}
+		certPool := x509.NewCertPool()
+		certPool.AppendCertsFromPEM(cert)
+		tlsConfig.RootCAs = certPool
+	}
+	if clientCertPath != "" || clientKeyPath != "" {
+		if clientCertPath == "" {
+			return nil, &ErrInvalidDSN{Param: sSLClientCertPathConfig, Reason: "required with " + sSLClientKeyPathConfig}
+		}
+		if clientKeyPath == "" {
+			return nil, &ErrInvalidDSN{Param: sSLClientKeyPathConfig, Reason: "required with " + sSLClientCertPathConfig}
+		}
+		cert, err := tls.LoadX509KeyPair(clientCertPath, clientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("presto: Error loading SSL client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// processUser returns the name of the user running the process, which like
+// in the presto CLI is the default user of queries.
+func processUser() string {
+	if u, err := osuser.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// parseDSN parses and validates the URL and the structured parameters of a
+// DSN, so that malformed ones fail when the database is opened.
+func parseDSN(dsn string) (*url.URL, error) {
 	prestoURL, err := url.Parse(dsn)
 	if err != nil {
-		return nil, fmt.Errorf("presto: malformed dsn: %v", err)
+		return nil, &ErrInvalidDSN{Reason: err.Error()}
+	}
+	if prestoURL.Scheme != "http" && prestoURL.Scheme != "https" {
+		return nil, &ErrInvalidDSN{Reason: fmt.Sprintf("unsupported scheme %q, want http or https", prestoURL.Scheme)}
+	}
+	if err := validateHost(prestoURL.Host); err != nil {
+		return nil, &ErrInvalidDSN{Reason: err.Error()}
+	}
+	if v := prestoURL.Query().Get("session_properties"); v != "" {
+		for _, kv := range strings.Split(v, ",") {
+			if k, _, ok := strings.Cut(kv, "="); !ok || !sessionPropertyName.MatchString(strings.TrimSpace(k)) {
+				return nil, &ErrInvalidDSN{Param: "session_properties", Value: v}
+			}
+		}
+	}
+	if _, err := parseCoordinators(prestoURL, prestoURL.Query().Get("coordinators")); err != nil {
+		return nil, err
+	}
+	return prestoURL, nil
+}
+
+// validateHost checks a host with an optional port, where IPv6 addresses
+// are enclosed in brackets, e.g. [::1]:8080.
+func validateHost(host string) error {
+	if host == "" {
+		return errors.New("missing host")
+	}
+	name, port := host, ""
+	if strings.HasPrefix(host, "[") {
+		end := strings.Index(host, "]")
+		if end < 0 || host[end+1:] != "" && host[end+1] != ':' {
+			return fmt.Errorf("malformed host %q", host)
+		}
+		name, port = host[1:end], strings.TrimPrefix(host[end+1:], ":")
+	} else if i := strings.LastIndex(host, ":"); i >= 0 {
+		name, port = host[:i], host[i+1:]
+		if strings.Contains(name, ":") {
+			return fmt.Errorf("IPv6 address in host %q must be enclosed in brackets", host)
+		}
+	}
+	if name == "" {
+		return fmt.Errorf("missing host name in %q", host)
+	}
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid port in host %q", host)
+		}
+	}
+	return nil
+}
+
+func newConn(dsn string) (*Conn, error) {
+	prestoURL, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
 	}
 
 	prestoQuery := prestoURL.Query()
@@ -253,65 +958,585 @@ func newConn(dsn string) (*Conn, error) {
 		if httpClient == nil {
 			return nil, fmt.Errorf("presto: custom client not registered: %q", clientKey)
 		}
-	} else if certPath := prestoQuery.Get(sSLCertPathConfig); certPath != "" && prestoURL.Scheme == "https" {
-		cert, err := os.ReadFile(certPath)
+	} else if prestoURL.Scheme == "https" {
+		tlsConfig, err := newTLSConfig(prestoQuery)
 		if err != nil {
-			return nil, fmt.Errorf("presto: Error loading SSL Cert File: %v", err)
+			return nil, err
 		}
-		certPool := x509.NewCertPool()
-		certPool.AppendCertsFromPEM(cert)
-
-		httpClient = &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					RootCAs: certPool,
+		if tlsConfig != nil {
+			httpClient = &http.Client{
+				Transport: &http.Transport{
+					TLSClientConfig: tlsConfig,
 				},
-			},
+			}
 		}
 	}
 
-	c := &Conn{
-		baseURL:         prestoURL.Scheme + "://" + prestoURL.Host,
-		httpClient:      *httpClient,
-		httpHeaders:     make(http.Header),
-		kerberosClient:  kerberosClient,
-		kerberosEnabled: kerberosEnabled,
+	maxRedirects := defaultMaxRedirects
+	if v := prestoQuery.Get("max_redirects"); v != "" {
+		maxRedirects, err = strconv.Atoi(v)
+		if err != nil || maxRedirects < 0 {
+			return nil, &ErrInvalidDSN{Param: "max_redirects", Value: v}
+		}
+	}
+	if v := prestoQuery.Get("follow_redirects"); v != "" {
+		follow, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, &ErrInvalidDSN{Param: "follow_redirects", Value: v}
+		}
+		if !follow {
+			maxRedirects = 0
+		}
+	}
+
+	var maxQueuedTime time.Duration
+	if v := prestoQuery.Get("max_queued_time"); v != "" {
+		maxQueuedTime, err = time.ParseDuration(v)
+		if err != nil || maxQueuedTime < 0 {
+			return nil, &ErrInvalidDSN{Param: "max_queued_time", Value: v}
+		}
+	}
+	var queuedRetries int
+	if v := prestoQuery.Get("queued_retries"); v != "" {
+		queuedRetries, err = strconv.Atoi(v)
+		if err != nil || queuedRetries < 0 {
+			return nil, &ErrInvalidDSN{Param: "queued_retries", Value: v}
+		}
+	}
+
+	var queueFullRetries int
+	if v := prestoQuery.Get("queue_full_retries"); v != "" {
+		queueFullRetries, err = strconv.Atoi(v)
+		if err != nil || queueFullRetries < 0 {
+			return nil, &ErrInvalidDSN{Param: "queue_full_retries", Value: v}
+		}
+	}
+	var clientQueueSize int
+	if v := prestoQuery.Get("client_queue_size"); v != "" {
+		clientQueueSize, err = strconv.Atoi(v)
+		if err != nil || clientQueueSize < 0 {
+			return nil, &ErrInvalidDSN{Param: "client_queue_size", Value: v}
+		}
+	}
+
+	var resourceRetries int
+	if v := prestoQuery.Get("resource_retries"); v != "" {
+		resourceRetries, err = strconv.Atoi(v)
+		if err != nil || resourceRetries < 0 {
+			return nil, &ErrInvalidDSN{Param: "resource_retries", Value: v}
+		}
+	}
+	resourceBackoff := DefaultResourceRetryBackoff
+	if v := prestoQuery.Get("resource_retry_backoff"); v != "" {
+		resourceBackoff, err = time.ParseDuration(v)
+		if err != nil || resourceBackoff <= 0 {
+			return nil, &ErrInvalidDSN{Param: "resource_retry_backoff", Value: v}
+		}
+	}
+
+	var submissionRetries int
+	if v := prestoQuery.Get("submission_retries"); v != "" {
+		submissionRetries, err = strconv.Atoi(v)
+		if err != nil || submissionRetries < 0 {
+			return nil, &ErrInvalidDSN{Param: "submission_retries", Value: v}
+		}
+	}
+
+	var inListThreshold int
+	if v := prestoQuery.Get("in_list_threshold"); v != "" {
+		inListThreshold, err = strconv.Atoi(v)
+		if err != nil || inListThreshold < 0 {
+			return nil, &ErrInvalidDSN{Param: "in_list_threshold", Value: v}
+		}
+	}
+
+	var compressThreshold int
+	if v := prestoQuery.Get("compress_threshold"); v != "" {
+		compressThreshold, err = strconv.Atoi(v)
+		if err != nil || compressThreshold < 0 {
+			return nil, &ErrInvalidDSN{Param: "compress_threshold", Value: v}
+		}
+	}
+
+	var maxStatementSize int
+	if v := prestoQuery.Get("max_statement_size"); v != "" {
+		maxStatementSize, err = strconv.Atoi(v)
+		if err != nil || maxStatementSize < 0 {
+			return nil, &ErrInvalidDSN{Param: "max_statement_size", Value: v}
+		}
+	}
+
+	var emptyPageMinBackoff time.Duration
+	if v := prestoQuery.Get("empty_page_min_backoff"); v != "" {
+		emptyPageMinBackoff, err = time.ParseDuration(v)
+		if err != nil || emptyPageMinBackoff < 0 {
+			return nil, &ErrInvalidDSN{Param: "empty_page_min_backoff", Value: v}
+		}
+	}
+	emptyPageMaxBackoff := DefaultEmptyPageMaxBackoff
+	if v := prestoQuery.Get("empty_page_max_backoff"); v != "" {
+		emptyPageMaxBackoff, err = time.ParseDuration(v)
+		if err != nil || emptyPageMaxBackoff < emptyPageMinBackoff {
+			return nil, &ErrInvalidDSN{Param: "empty_page_max_backoff", Value: v}
+		}
+	} else if emptyPageMaxBackoff < emptyPageMinBackoff {
+		emptyPageMaxBackoff = emptyPageMinBackoff
+	}
+
+	prefix := preparedStatementPrefix
+	if v := prestoQuery.Get("prepared_statement_prefix"); v != "" {
+		if !statementNamePrefix.MatchString(v) {
+			return nil, &ErrInvalidDSN{Param: "prepared_statement_prefix", Value: v}
+		}
+		prefix = v
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("presto: generating prepared statement name: %v", err)
+	}
+
+	coordinators, err := parseCoordinators(prestoURL, prestoQuery.Get("coordinators"))
+	if err != nil {
+		return nil, err
+	}
+	affinity := queryAffinity
+	if v := prestoQuery.Get("coordinator_affinity"); v != "" {
+		if v != queryAffinity && v != connectionAffinity {
+			return nil, &ErrInvalidDSN{Param: "coordinator_affinity", Value: v}
+		}
+		affinity = v
 	}
 
-	var user string
+	c := &Conn{
+		baseURL:          coordinators[atomic.AddUint32(&nextCoordinator, 1)%uint32(len(coordinators))],
+		coordinators:     coordinators,
+		affinity:         affinity,
+		httpClient:       *httpClient,
+		httpHeaders:      make(http.Header),
+		kerberosClient:   kerberosClient,
+		kerberosEnabled:  kerberosEnabled,
+		maxRedirects:     maxRedirects,
+		maxQueuedTime:    maxQueuedTime,
+		queuedRetries:    queuedRetries,
+		queueFullRetries: queueFullRetries,
+		queue:            newClientQueue(clientQueueSize),
+		resourceRetries:  resourceRetries,
+		resourceBackoff:  resourceBackoff,
+
+		submissionRetries: submissionRetries,
+		inListThreshold:   inListThreshold,
+
+		compressThreshold: compressThreshold,
+		maxStatementSize:  maxStatementSize,
+
+		preparedStatementName: prefix + "_" + hex.EncodeToString(suffix),
+		timestampLocation:     time.Local,
+
+		emptyPageMinBackoff: emptyPageMinBackoff,
+		emptyPageMaxBackoff: emptyPageMaxBackoff,
+	}
+
+	var user, pass string
 	if prestoURL.User != nil {
 		user = prestoURL.User.Username()
-		pass, _ := prestoURL.User.Password()
-		if pass != "" && prestoURL.Scheme == "https" {
-			c.auth = prestoURL.User
+		pass, _ = prestoURL.User.Password()
+	}
+	if user == "" {
+		user = prestoQuery.Get("user")
+	}
+	if user == "" {
+		user = processUser()
+	}
+	if pass != "" {
+		insecure := false
+		if v := prestoQuery.Get("insecure_basic_auth"); v != "" {
+			if insecure, err = strconv.ParseBool(v); err != nil {
+				return nil, &ErrInvalidDSN{Param: "insecure_basic_auth", Value: v}
+			}
+		}
+		if prestoURL.Scheme != "https" && !insecure {
+			// The password is left out of the error, which may be logged.
+			return nil, &ErrInvalidDSN{Reason: "basic authentication requires https, or insecure_basic_auth=true"}
+		}
+		c.auth = url.UserPassword(user, pass)
+	}
+
+	// The queries run as the first principal of the delegation chain, and
+	// the chain is recorded in the client info, which event listeners log,
+	// with the user that authenticates last.
+	sessionUser := user
+	if v := prestoQuery.Get("on_behalf_of"); v != "" {
+		chain := strings.Split(v, ",")
+		for _, p := range chain {
+			if p == "" || strings.TrimSpace(p) != p {
+				return nil, &ErrInvalidDSN{Param: "on_behalf_of", Value: v}
+			}
 		}
+		sessionUser = chain[0]
+		c.httpHeaders.Set(prestoClientInfoHeader, "on_behalf_of="+v+"; principal="+user)
 	}
 
+	source := prestoQuery.Get("source")
+	if suffix := prestoQuery.Get("source_suffix"); suffix != "" {
+		if source == "" {
+			source = clientName
+		}
+		source += " " + suffix
+	}
+	userAgent := clientName
+	if v := prestoQuery.Get("user_agent"); v != "" {
+		userAgent = v + " " + clientName
+	}
+	c.httpHeaders.Set("User-Agent", userAgent)
+
 	for k, v := range map[string]string{
-		prestoUserHeader:    user,
-		prestoSourceHeader:  prestoQuery.Get("source"),
-		prestoCatalogHeader: prestoQuery.Get("catalog"),
-		prestoSchemaHeader:  prestoQuery.Get("schema"),
-		prestoSessionHeader: prestoQuery.Get("session_properties"),
+		prestoUserHeader:        sessionUser,
+		prestoSourceHeader:      source,
+		prestoCatalogHeader:     prestoQuery.Get("catalog"),
+		prestoSchemaHeader:      prestoQuery.Get("schema"),
+		prestoSessionHeader:     prestoQuery.Get("session_properties"),
+		prestoTransactionHeader: prestoQuery.Get("transaction_id"),
 	} {
 		if v != "" {
 			c.httpHeaders.Add(k, v)
 		}
 	}
 
-	// if a JWT access token is provided, add an Authorization header with Bearer token
-	if token := prestoQuery.Get(accessTokenConfig); token != "" {
-		c.httpHeaders.Set("Authorization", "Bearer "+token)
+	if v := prestoQuery.Get("client_capabilities"); v != "" {
+		for _, capability := range strings.Split(v, ",") {
+			if capability == "" || strings.TrimSpace(capability) != capability {
+				return nil, &ErrInvalidDSN{Param: "client_capabilities", Value: v}
+			}
+		}
+		c.httpHeaders.Set(prestoClientCapabilitiesHeader, v)
 	}
 
-	return c, nil
-}
+	if v := prestoQuery.Get("client_tags"); v != "" {
+		for _, tag := range strings.Split(v, ",") {
+			if tag == "" || strings.TrimSpace(tag) != tag {
+				return nil, &ErrInvalidDSN{Param: "client_tags", Value: v}
+			}
+		}
+		c.httpHeaders.Set(prestoClientTagsHeader, v)
+	}
 
-// registry for custom http clients
-var customClientRegistry = struct {
-	sync.RWMutex
-	Index map[string]http.Client
-}{
+	if v := prestoQuery.Get("trace_token"); v != "" {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, &ErrInvalidDSN{Param: "trace_token", Value: v}
+		}
+		c.traceToken = v
+	}
+
+	if v := prestoQuery.Get("cookies"); v != "" {
+		cookies, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, &ErrInvalidDSN{Param: "cookies", Value: v}
+		}
+		// Each connection keeps the affinity cookies of load balancers in
+		// its own jar, unless its custom client has one.
+		if cookies && c.httpClient.Jar == nil {
+			c.httpClient.Jar, _ = cookiejar.New(nil)
+		}
+	}
+
+	if v := prestoQuery.Get("execute_immediate"); v != "" {
+		immediate, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, &ErrInvalidDSN{Param: "execute_immediate", Value: v}
+		}
+		c.executeImmediate = immediate
+	}
+
+	if v := prestoQuery.Get("describe_input"); v != "" {
+		if c.describeInput, err = strconv.ParseBool(v); err != nil {
+			return nil, &ErrInvalidDSN{Param: "describe_input", Value: v}
+		}
+	}
+
+	if v := prestoQuery.Get("query_annotations"); v != "" {
+		if c.annotations, err = parseAnnotations(v); err != nil {
+			return nil, err
+		}
+	}
+
+	if v := prestoQuery.Get("roles"); v != "" {
+		if c.roles, err = parseRoles(v); err != nil {
+			return nil, err
+		}
+		c.setRoleHeaders()
+	}
+
+	if v := prestoQuery.Get("number_decoding"); v != "" {
+		if c.numberDecoding, err = parseNumberDecoding(v); err != nil {
+			return nil, err
+		}
+	}
+
+	if v := prestoQuery.Get("require_deadline"); v != "" {
+		require, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, &ErrInvalidDSN{Param: "require_deadline", Value: v}
+		}
+		c.requireDeadline = require
+	}
+
+	// query_timeout is an alias of default_deadline, as named by other
+	// drivers.
+	for _, param := range []string{"default_deadline", "query_timeout"} {
+		v := prestoQuery.Get(param)
+		if v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, &ErrInvalidDSN{Param: param, Value: v}
+		}
+		if c.defaultDeadline > 0 && c.defaultDeadline != d {
+			return nil, &ErrInvalidDSN{Param: param, Value: v, Reason: "conflicts with default_deadline"}
+		}
+		c.defaultDeadline = d
+	}
+
+	if v := prestoQuery.Get("retry_budget"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, &ErrInvalidDSN{Param: "retry_budget", Value: v}
+		}
+		c.retryBudget = n
+	}
+
+	if v := prestoQuery.Get("retry_budget_time"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, &ErrInvalidDSN{Param: "retry_budget_time", Value: v}
+		}
+		c.retryBudgetTime = d
+	}
+
+	if v := prestoQuery.Get("strict_decoding"); v != "" {
+		strict, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, &ErrInvalidDSN{Param: "strict_decoding", Value: v}
+		}
+		c.strictDecoding = strict
+	}
+
+	if v := prestoQuery.Get("sized_integers"); v != "" {
+		sized, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, &ErrInvalidDSN{Param: "sized_integers", Value: v}
+		}
+		c.sizedIntegers = sized
+	}
+
+	if v := prestoQuery.Get("debug"); v != "" {
+		debug, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, &ErrInvalidDSN{Param: "debug", Value: v}
+		}
+		if debug {
+			c.debug = os.Stderr
+		}
+	}
+
+	if v := prestoQuery.Get("query_data_encoding"); v != "" {
+		if v != jsonDataEncoding {
+			return nil, fmt.Errorf("presto: unsupported query_data_encoding: %q", v)
+		}
+		c.httpHeaders.Set(prestoQueryDataEncodingHeader, v)
+	}
+
+	if v := prestoQuery.Get("time_zone"); v != "" {
+		loc, err := loadZone(v)
+		if err != nil || v == "Local" {
+			return nil, &ErrInvalidDSN{Param: "time_zone", Value: v}
+		}
+		// Unless the new timestamp semantics are set below, timestamps
+		// are rendered in the session time zone.
+		c.timestampLocation = loc
+		c.httpHeaders.Set(prestoTimeZoneHeader, v)
+	}
+
+	if v := prestoQuery.Get("language"); v != "" {
+		if !languageTag.MatchString(v) {
+			return nil, &ErrInvalidDSN{Param: "language", Value: v}
+		}
+		c.httpHeaders.Set(prestoLanguageHeader, v)
+	}
+
+	if v := prestoQuery.Get(legacyTimestampConfig); v != "" {
+		legacy, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, &ErrInvalidDSN{Param: legacyTimestampConfig, Value: v}
+		}
+		// With the new semantics timestamps are wall clock values, which
+		// are represented in UTC; legacy timestamps are instants rendered
+		// in the session time zone.
+		if !legacy {
+			c.timestampLocation = time.UTC
+		}
+		if err = c.SetSessionProperty(context.Background(), legacyTimestampConfig, strconv.FormatBool(legacy)); err != nil {
+			return nil, err
+		}
+	}
+
+	// if a JWT access token is provided, it is sent as a Bearer token
+	c.accessToken = prestoQuery.Get(accessTokenConfig)
+
+	return c, nil
+}
+
+// SetSessionProperty sets a session property sent with all subsequent
+// queries on the connection, named catalog.property for the properties of a
+// catalog. The underlying connection is available through (*sql.Conn).Raw:
+//
+//	conn, _ := db.Conn(ctx)
+//	err := conn.Raw(func(dc interface{}) error {
+//		return dc.(*presto.Conn).SetSessionProperty(ctx, "query_max_run_time", "10m")
+//	})
+//
+// SetSession does so for several properties at once.
+func (c *Conn) SetSessionProperty(ctx context.Context, name, value string) error {
+	if !sessionPropertyName.MatchString(name) || strings.Contains(value, ",") {
+		return fmt.Errorf("presto: invalid session property: %q=%q", name, value)
+	}
+	props := c.sessionProperties()
+	found := false
+	for i := range props {
+		if props[i][0] == name {
+			props[i][1] = value
+			found = true
+		}
+	}
+	if !found {
+		props = append(props, [2]string{name, value})
+	}
+	c.setSessionProperties(props)
+	return nil
+}
+
+// Catalog returns the catalog of the queries of the connection, as set by
+// the DSN or by a USE statement, empty if none. Like SetSessionProperty it
+// is available through (*sql.Conn).Raw.
+func (c *Conn) Catalog() string {
+	return c.httpHeaders.Get(prestoCatalogHeader)
+}
+
+// Schema returns the schema of the queries of the connection, as set by the
+// DSN or by a USE statement, empty if none.
+func (c *Conn) Schema() string {
+	return c.httpHeaders.Get(prestoSchemaHeader)
+}
+
+// updateCatalogSchema applies the catalog and schema set by a USE
+// statement, as sent in the response headers, to the subsequent queries of
+// the connection.
+func (c *Conn) updateCatalogSchema(h http.Header) {
+	if v := h.Get(prestoSetCatalogHeader); v != "" {
+		c.httpHeaders.Set(prestoCatalogHeader, v)
+	}
+	if v := h.Get(prestoSetSchemaHeader); v != "" {
+		c.httpHeaders.Set(prestoSchemaHeader, v)
+	}
+}
+
+// ResetSessionProperty removes a session property from the connection, so
+// subsequent queries use the server default.
+func (c *Conn) ResetSessionProperty(ctx context.Context, name string) error {
+	props := c.sessionProperties()
+	kept := props[:0]
+	for _, p := range props {
+		if p[0] != name {
+			kept = append(kept, p)
+		}
+	}
+	c.setSessionProperties(kept)
+	return nil
+}
+
+// SetSession sets session properties of the presto connection underlying
+// conn, sent with all its subsequent queries, without leaving database/sql:
+//
+//	conn, _ := db.Conn(ctx)
+//	defer conn.Close()
+//	err := presto.SetSession(ctx, conn, map[string]string{"query_max_run_time": "10m"})
+//
+// No property is set if any is invalid. The properties stay set when conn
+// returns to the pool of db.
+func SetSession(ctx context.Context, conn *sql.Conn, props map[string]string) error {
+	return rawConn(conn, func(c *Conn) error {
+		names := make([]string, 0, len(props))
+		for name, value := range props {
+			if !sessionPropertyName.MatchString(name) || strings.Contains(value, ",") {
+				return fmt.Errorf("presto: invalid session property: %q=%q", name, value)
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if err := c.SetSessionProperty(ctx, name, props[name]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ResetSession removes session properties from the presto connection
+// underlying conn, so its subsequent queries use the server defaults.
+func ResetSession(ctx context.Context, conn *sql.Conn, names ...string) error {
+	return rawConn(conn, func(c *Conn) error {
+		for _, name := range names {
+			if err := c.ResetSessionProperty(ctx, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// rawConn calls f with the presto connection underlying conn.
+func rawConn(conn *sql.Conn, f func(c *Conn) error) error {
+	return conn.Raw(func(dc interface{}) error {
+		c, ok := dc.(*Conn)
+		if !ok {
+			return fmt.Errorf("presto: not a presto connection: %T", dc)
+		}
+		return f(c)
+	})
+}
+
+// sessionProperties returns the name and value pairs of the session header.
+func (c *Conn) sessionProperties() [][2]string {
+	var props [][2]string
+	for _, h := range c.httpHeaders.Values(prestoSessionHeader) {
+		for _, kv := range strings.Split(h, ",") {
+			k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+			if ok {
+				props = append(props, [2]string{k, v})
+			}
+		}
+	}
+	return props
+}
+
+func (c *Conn) setSessionProperties(props [][2]string) {
+	if len(props) == 0 {
+		c.httpHeaders.Del(prestoSessionHeader)
+		return
+	}
+	kvs := make([]string, len(props))
+	for i, p := range props {
+		kvs[i] = p[0] + "=" + p[1]
+	}
+	c.httpHeaders.Set(prestoSessionHeader, strings.Join(kvs, ","))
+}
+
+// registry for custom http clients
+var customClientRegistry = struct {
+	sync.RWMutex
+	Index map[string]http.Client
+}{
 	Index: make(map[string]http.Client),
 }
 
@@ -348,150 +1573,658 @@ func RegisterCustomClient(key string, client *http.Client) error {
 	return nil
 }
 
-// DeregisterCustomClient removes the client associated to the key.
-func DeregisterCustomClient(key string) {
-	customClientRegistry.Lock()
-	delete(customClientRegistry.Index, key)
-	customClientRegistry.Unlock()
+// DeregisterCustomClient removes the client associated to the key.
+func DeregisterCustomClient(key string) {
+	customClientRegistry.Lock()
+	delete(customClientRegistry.Index, key)
+	customClientRegistry.Unlock()
+}
+
+func getCustomClient(key string) *http.Client {
+	customClientRegistry.RLock()
+	defer customClientRegistry.RUnlock()
+	if client, ok := customClientRegistry.Index[key]; ok {
+		return &client
+	}
+	return nil
+}
+
+// Begin implements the driver.Conn interface.
+func (c *Conn) Begin() (driver.Tx, error) {
+	return nil, ErrOperationNotSupported
+}
+
+func (c *Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	args := []string{}
+	if opts.ReadOnly {
+		args = append(args, "READ ONLY")
+	}
+
+	level := sql.IsolationLevel(opts.Isolation)
+	if level != sql.LevelDefault {
+		err := verifyIsolationLevel(level)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, fmt.Sprintf("ISOLATION LEVEL %s", level.String()))
+	}
+
+	query := fmt.Sprintf("START TRANSACTION %s", strings.Join(args, ", "))
+	c.httpHeaders.Set(prestoTransactionHeader, "NONE")
+	stmt := &driverStmt{conn: c, query: query}
+	err := stmt.exec(ctx)
+	if err != nil {
+		c.httpHeaders.Del(prestoTransactionHeader)
+		return nil, err
+	}
+
+	return &driverTx{conn: c}, nil
+}
+
+// Prepare implements the driver.Conn interface.
+func (c *Conn) Prepare(query string) (driver.Stmt, error) {
+	return nil, driver.ErrSkip
+}
+
+// PrepareContext implements the driver.ConnPrepareContext interface.
+func (c *Conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return &driverStmt{conn: c, query: query}, nil
+}
+
+// QueryContext implements the driver.QueryerContext interface, running the
+// query without preparing a statement first.
+func (c *Conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	st := &driverStmt{conn: c, query: query}
+	return st.QueryContext(ctx, args)
+}
+
+// ExecContext implements the driver.ExecerContext interface.
+func (c *Conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	st := &driverStmt{conn: c, query: query}
+	return st.ExecContext(ctx, args)
+}
+
+// Close implements the driver.Conn interface.
+func (c *Conn) Close() error {
+	return nil
+}
+
+func (c *Conn) newRequest(method, url string, body io.Reader, hs http.Header) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("presto: %v", err)
+	}
+
+	for k, v := range c.httpHeaders {
+		req.Header[k] = v
+	}
+	for k, v := range hs {
+		req.Header[k] = v
+	}
+	return req, nil
+}
+
+func (c *Conn) roundTrip(ctx context.Context, req *http.Request) (*http.Response, error) {
+	delay := 100 * time.Millisecond
+	const maxDelayBetweenRequests = float64(15 * time.Second)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	var rateLimited *ErrRateLimited
+	var authFailures []AuthFailure
+	ctx = withAuthState(ctx)
+	redirects := 0
+	attempt := 0
+	for {
+		select {
+		case <-ctx.Done():
+			if rateLimited != nil {
+				rateLimited.Reason = ctx.Err()
+				return nil, rateLimited
+			}
+			return nil, ctx.Err()
+		case <-timer.C:
+			timeout := DefaultQueryTimeout
+			if deadline, ok := ctx.Deadline(); ok {
+				timeout = deadline.Sub(time.Now())
+			}
+			client, custom := c.clientFor(ctx)
+			if !custom || client.Timeout == 0 {
+				client.Timeout = timeout
+			}
+			if !custom || client.CheckRedirect == nil {
+				client.CheckRedirect = c.checkRedirect
+			}
+			atomic.AddInt64(&driverStats.requests, 1)
+			if err := c.authorize(ctx, req); err != nil {
+				if c.authFallback(ctx, &authFailures, 0, err) {
+					timer.Reset(0)
+					continue
+				}
+				return nil, authError(authFailures, &ErrQueryFailed{Reason: err})
+			}
+			attempt++
+			resp, err := c.do(&client, req, attempt)
+			if err != nil {
+				return nil, &ErrQueryFailed{Reason: &ErrTransport{Err: err}}
+			}
+			switch resp.StatusCode {
+			case http.StatusOK:
+				if id := resp.Header.Get(prestoStartedTransactionHeader); id != "" {
+					c.httpHeaders.Set(prestoTransactionHeader, id)
+				} else if resp.Header.Get(prestoClearTransactionHeader) == "true" {
+					c.httpHeaders.Del(prestoTransactionHeader)
+				}
+				c.updateRoles(resp.Header)
+				c.updateCatalogSchema(resp.Header)
+
+				return resp, nil
+			case http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+				if redirects >= c.maxRedirects {
+					return nil, newErrQueryFailedFromResponse(resp)
+				}
+				resp.Body.Close()
+				req, err = c.redirectRequest(req, resp)
+				if err != nil {
+					return nil, err
+				}
+				redirects++
+				timer.Reset(0)
+				continue
+			case http.StatusUnauthorized:
+				qf := newErrQueryFailedFromResponse(resp)
+				if c.authFallback(ctx, &authFailures, resp.StatusCode, qf) {
+					if req.GetBody != nil {
+						if req.Body, err = req.GetBody(); err != nil {
+							return nil, &ErrQueryFailed{Reason: err}
+						}
+					}
+					timer.Reset(0)
+					continue
+				}
+				return nil, authError(authFailures, qf)
+			case http.StatusServiceUnavailable, http.StatusTooManyRequests:
+				resp.Body.Close()
+				wait := delay
+				if resp.StatusCode == http.StatusTooManyRequests {
+					atomic.AddInt64(&driverStats.rateLimited, 1)
+					rateLimited = &ErrRateLimited{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+					if rateLimited.RetryAfter > wait {
+						wait = rateLimited.RetryAfter
+					}
+					if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+						rateLimited.Reason = context.DeadlineExceeded
+						return nil, rateLimited
+					}
+				} else {
+					atomic.AddInt64(&driverStats.retries, 1)
+				}
+				if b, ok := ctx.Value(retryBudgetKey).(*retryBudget); ok {
+					if err := b.spend(wait, resp.StatusCode); err != nil {
+						return nil, err
+					}
+				}
+				if req.GetBody != nil {
+					if req.Body, err = req.GetBody(); err != nil {
+						return nil, &ErrQueryFailed{Reason: err}
+					}
+				}
+				timer.Reset(wait)
+				delay = time.Duration(math.Min(
+					float64(delay)*math.Phi,
+					maxDelayBetweenRequests,
+				))
+				continue
+			default:
+				return nil, newErrQueryFailedFromResponse(resp)
+			}
+		}
+	}
+}
+
+// checkRedirect stops the http.Client from following 307 and 308 redirects,
+// which roundTrip follows itself so the statement body and presto headers
+// reach the coordinator behind a gateway. Other redirects keep the default
+// behavior of the configured client.
+func (c *Conn) checkRedirect(req *http.Request, via []*http.Request) error {
+	if req.Response != nil {
+		switch req.Response.StatusCode {
+		case http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			return http.ErrUseLastResponse
+		}
+	}
+	if c.httpClient.CheckRedirect != nil {
+		return c.httpClient.CheckRedirect(req, via)
+	}
+	if len(via) >= defaultMaxRedirects {
+		return fmt.Errorf("stopped after %d redirects", defaultMaxRedirects)
+	}
+	return nil
+}
+
+// redirectRequest returns a copy of req targeting the location of the
+// redirect response, with the same method, body and headers.
+func (c *Conn) redirectRequest(req *http.Request, resp *http.Response) (*http.Request, error) {
+	loc, err := resp.Location()
+	if err != nil {
+		return nil, &ErrQueryFailed{StatusCode: resp.StatusCode, Reason: &ErrProtocol{StatusCode: resp.StatusCode, Err: err}}
+	}
+	r := req.Clone(req.Context())
+	r.URL = loc
+	r.Host = ""
+	if req.GetBody != nil {
+		if r.Body, err = req.GetBody(); err != nil {
+			return nil, &ErrQueryFailed{StatusCode: resp.StatusCode, Reason: err}
+		}
+	}
+	return r, nil
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either
+// a number of seconds or an HTTP date. It returns zero if the value is
+// missing or malformed.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// ErrRateLimited indicates that presto, or a gateway in front of it, kept
+// rejecting requests with 429 Too Many Requests until the query context
+// expired, or asked to retry after the context deadline.
+type ErrRateLimited struct {
+	RetryAfter time.Duration // Delay requested by the last Retry-After header
+	Reason     error
+}
+
+// Error implements the error interface.
+func (e *ErrRateLimited) Error() string {
+	return fmt.Sprintf("presto: rate limited (retry after %v): %v", e.RetryAfter, e.Reason)
+}
+
+// Unwrap returns the reason the driver stopped retrying.
+func (e *ErrRateLimited) Unwrap() error {
+	return e.Reason
+}
+
+// ErrRetryBudgetExceeded indicates that a query used up the retries allowed
+// by the retry_budget and retry_budget_time DSN parameters, on requests
+// answered with 503 Service Unavailable or 429 Too Many Requests, and on
+// resubmissions and re-executions of the query.
+type ErrRetryBudgetExceeded struct {
+	Retries    int           // Number of requests retried by the query, including resubmissions
+	Waited     time.Duration // Time spent waiting to retry requests
+	StatusCode int           // Status of the response that would have been retried, zero if none
+}
+
+// Error implements the error interface.
+func (e *ErrRetryBudgetExceeded) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("presto: retry budget exceeded after %d retries and %v waiting", e.Retries, e.Waited)
+	}
+	return fmt.Sprintf("presto: retry budget exceeded after %d retries and %v waiting (last status %d %s)",
+		e.Retries, e.Waited, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// retryBudget counts the retries of the requests of a query, shared by its
+// submission and page fetches through its context, and its resubmissions
+// and re-executions.
+type retryBudget struct {
+	maxRetries int
+	maxWait    time.Duration
+	retries    int
+	waited     time.Duration
+}
+
+// spend records a retry after waiting for the given time, unless it
+// exceeds the budget.
+func (b *retryBudget) spend(wait time.Duration, status int) error {
+	if (b.maxRetries > 0 && b.retries >= b.maxRetries) || (b.maxWait > 0 && b.waited+wait > b.maxWait) {
+		return &ErrRetryBudgetExceeded{Retries: b.retries, Waited: b.waited, StatusCode: status}
+	}
+	b.retries++
+	b.waited += wait
+	return nil
+}
+
+// spendRetryBudget records a resubmission of the query of ctx that failed
+// with cause, after waiting for the given time, unless it exceeds the
+// retry budget of the query if any.
+func spendRetryBudget(ctx context.Context, wait time.Duration, cause error) error {
+	b, ok := ctx.Value(retryBudgetKey).(*retryBudget)
+	if !ok {
+		return nil
+	}
+	var qf *ErrQueryFailed
+	status := 0
+	if errors.As(cause, &qf) {
+		status = qf.StatusCode
+	}
+	return b.spend(wait, status)
+}
+
+// ErrQueryAbandoned indicates that a query was abandoned mid-execution
+// because its context was cancelled or expired. The driver then deletes the
+// query in presto, with a deadline of DefaultCancelQueryTimeout independent
+// of the context, and Acknowledged reports whether presto confirmed it;
+// otherwise the query may keep running until presto expires it.
+type ErrQueryAbandoned struct {
+	QueryID      string
+	Acknowledged bool
+	Reason       error // Error of the context
+}
+
+// Error implements the error interface.
+func (e *ErrQueryAbandoned) Error() string {
+	if !e.Acknowledged {
+		return fmt.Sprintf("presto: query %s abandoned without confirmation of its cancellation: %v", e.QueryID, e.Reason)
+	}
+	return fmt.Sprintf("presto: query %s cancelled: %v", e.QueryID, e.Reason)
+}
+
+// Unwrap returns the error of the context.
+func (e *ErrQueryAbandoned) Unwrap() error {
+	return e.Reason
+}
+
+// ErrAmbiguousSubmission indicates that the submission of a query failed
+// without an answer from presto, and that it was not resubmitted because
+// the failed submission created the query, with the given ID, or because
+// that could not be checked, with an empty ID.
+type ErrAmbiguousSubmission struct {
+	QueryID string
+	Reason  error
+}
+
+// Error implements the error interface.
+func (e *ErrAmbiguousSubmission) Error() string {
+	if e.QueryID == "" {
+		return fmt.Sprintf("presto: submission failed and could not be checked: %v", e.Reason)
+	}
+	return fmt.Sprintf("presto: submission failed after creating query %s: %v", e.QueryID, e.Reason)
+}
+
+// Unwrap returns the error of the submission.
+func (e *ErrAmbiguousSubmission) Unwrap() error {
+	return e.Reason
+}
+
+// ErrQueryQueued indicates that a query remained queued in presto for longer
+// than the configured max_queued_time, and was cancelled.
+type ErrQueryQueued struct {
+	QueryID    string
+	QueuedTime time.Duration
+}
+
+// Error implements the error interface.
+func (e *ErrQueryQueued) Error() string {
+	return fmt.Sprintf("presto: query %s cancelled after being queued for %v", e.QueryID, e.QueuedTime)
+}
+
+// ErrStatementTooLarge indicates that a statement exceeds the configured
+// max_statement_size, and was not submitted.
+type ErrStatementTooLarge struct {
+	Size  int
+	Limit int
+}
+
+// Error implements the error interface.
+func (e *ErrStatementTooLarge) Error() string {
+	return fmt.Sprintf("presto: statement of %d bytes exceeds max_statement_size of %d bytes", e.Size, e.Limit)
+}
+
+// ErrInvalidDSN indicates that a DSN is malformed or has an invalid
+// parameter. Param is empty for errors in the URL itself, and Reason
+// details the error if needed.
+type ErrInvalidDSN struct {
+	Param  string
+	Value  string
+	Reason string
+}
+
+// Error implements the error interface.
+func (e *ErrInvalidDSN) Error() string {
+	if e.Param == "" {
+		return "presto: invalid dsn: " + e.Reason
+	}
+	msg := fmt.Sprintf("presto: invalid %s: %q", e.Param, e.Value)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// ErrorType is the category of a query failure reported by presto.
+type ErrorType string
+
+const (
+	// ErrorTypeUser indicates a problem with the query, such as a syntax
+	// error or a missing table.
+	ErrorTypeUser ErrorType = "USER_ERROR"
+	// ErrorTypeInternal indicates a bug or an unexpected failure in presto.
+	ErrorTypeInternal ErrorType = "INTERNAL_ERROR"
+	// ErrorTypeInsufficientResources indicates that the cluster ran out of
+	// resources, e.g. memory, and that the query may succeed if retried.
+	ErrorTypeInsufficientResources ErrorType = "INSUFFICIENT_RESOURCES"
+	// ErrorTypeExternal indicates a failure of a system presto depends on,
+	// such as a connector's data source.
+	ErrorTypeExternal ErrorType = "EXTERNAL"
+)
+
+// ErrQueryFailed indicates that a query to presto failed.
+// ErrorType is only set for failures reported by presto. Failures to reach
+// presto have an ErrTransport as Reason, and responses breaking the client
+// protocol, like unexpected HTTP statuses, an ErrProtocol, so that retry
+// logic can tell them apart with errors.As.
+type ErrQueryFailed struct {
+	StatusCode int
+	ErrorType  ErrorType
+	Reason     error
+}
+
+// Error implements the error interface.
+func (e *ErrQueryFailed) Error() string {
+	return fmt.Sprintf("presto: query failed (%d %s): %q",
+		e.StatusCode, http.StatusText(e.StatusCode), e.Reason)
+}
+
+// Unwrap returns the reason of the failure.
+func (e *ErrQueryFailed) Unwrap() error {
+	return e.Reason
 }
 
-func getCustomClient(key string) *http.Client {
-	customClientRegistry.RLock()
-	defer customClientRegistry.RUnlock()
-	if client, ok := customClientRegistry.Index[key]; ok {
-		return &client
-	}
-	return nil
+// ErrTransport indicates that a request to presto failed without a
+// response, e.g. on a DNS lookup, a TLS handshake, a refused or reset
+// connection, or a timeout of the HTTP client. Err is the error of the
+// HTTP client.
+type ErrTransport struct {
+	Err error
 }
 
-// Begin implements the driver.Conn interface.
-func (c *Conn) Begin() (driver.Tx, error) {
-	return nil, ErrOperationNotSupported
+// Error implements the error interface.
+func (e *ErrTransport) Error() string {
+	return e.Err.Error()
 }
 
-func (c *Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
-	args := []string{}
-	if opts.ReadOnly {
-		args = append(args, "READ ONLY")
-	}
+// Unwrap returns the error of the HTTP client.
+func (e *ErrTransport) Unwrap() error {
+	return e.Err
+}
 
-	level := sql.IsolationLevel(opts.Isolation)
-	if level != sql.LevelDefault {
-		err := verifyIsolationLevel(level)
-		if err != nil {
-			return nil, err
-		}
-		args = append(args, fmt.Sprintf("ISOLATION LEVEL %s", level.String()))
-	}
+// Timeout reports whether the request timed out.
+func (e *ErrTransport) Timeout() bool {
+	var ne net.Error
+	return errors.As(e.Err, &ne) && ne.Timeout()
+}
 
-	query := fmt.Sprintf("START TRANSACTION %s", strings.Join(args, ", "))
-	c.httpHeaders.Set(prestoTransactionHeader, "NONE")
-	stmt := &driverStmt{conn: c, query: query}
-	_, err := stmt.QueryContext(ctx, []driver.NamedValue{})
-	if err != nil {
-		c.httpHeaders.Del(prestoTransactionHeader)
-		return nil, err
-	}
+// TimeoutSource is the party whose time limit ended a query, as reported by
+// an ErrTimeout.
+type TimeoutSource int
 
-	return &driverTx{conn: c}, nil
+const (
+	// ClientTimeout is the deadline of the query context, the timeout of
+	// the HTTP client, or the max_queued_time DSN parameter.
+	ClientTimeout TimeoutSource = iota + 1
+	// ServerTimeout is a time limit of presto, such as the
+	// query_max_run_time or query_max_execution_time session properties.
+	ServerTimeout
+	// GatewayTimeout is the timeout of a proxy or load balancer in front
+	// of presto, which answered with a 504 or 408 status.
+	GatewayTimeout
+)
+
+// String implements the fmt.Stringer interface.
+func (s TimeoutSource) String() string {
+	switch s {
+	case ClientTimeout:
+		return "client"
+	case ServerTimeout:
+		return "server"
+	case GatewayTimeout:
+		return "gateway"
+	}
+	return "unknown"
 }
 
-// Prepare implements the driver.Conn interface.
-func (c *Conn) Prepare(query string) (driver.Stmt, error) {
-	return nil, driver.ErrSkip
+// ErrTimeout indicates that a query failed because it ran into a time
+// limit, and whose: Source tells the deadline of the client apart from the
+// time limits of presto and the idle timeouts of gateways. Err is the
+// error the query failed with, e.g. an ErrQueryFailed or an
+// ErrQueryAbandoned, which errors.As still finds.
+type ErrTimeout struct {
+	Source TimeoutSource
+	Err    error
 }
 
-// PrepareContext implements the driver.ConnPrepareContext interface.
-func (c *Conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
-	return &driverStmt{conn: c, query: query}, nil
+// Error implements the error interface.
+func (e *ErrTimeout) Error() string {
+	return fmt.Sprintf("%v (%s timeout)", e.Err, e.Source)
 }
 
-// Close implements the driver.Conn interface.
-func (c *Conn) Close() error {
-	return nil
+// Unwrap returns the error the query failed with.
+func (e *ErrTimeout) Unwrap() error {
+	return e.Err
 }
 
-func (c *Conn) newRequest(method, url string, body io.Reader, hs http.Header) (*http.Request, error) {
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return nil, fmt.Errorf("presto: %v", err)
-	}
+// Timeout reports that the query timed out, like a net.Error.
+func (e *ErrTimeout) Timeout() bool {
+	return true
+}
 
-	if c.kerberosEnabled {
-		err = c.kerberosClient.SetSPNEGOHeader(req, "presto/"+req.URL.Hostname())
-		if err != nil {
-			return nil, fmt.Errorf("error setting client SPNEGO header: %v", err)
-		}
+// classifyTimeout returns err wrapped in an ErrTimeout if the query failed
+// because of a time limit, and err otherwise.
+func classifyTimeout(err error) error {
+	if err == nil || err == io.EOF || err == sql.ErrNoRows {
+		return err
 	}
-
-	for k, v := range c.httpHeaders {
-		req.Header[k] = v
+	var te *ErrTimeout
+	if errors.As(err, &te) {
+		return err
 	}
-	for k, v := range hs {
-		req.Header[k] = v
+	if source := timeoutSource(err); source != 0 {
+		return &ErrTimeout{Source: source, Err: err}
 	}
+	return err
+}
 
-	if c.auth != nil {
-		pass, _ := c.auth.Password()
-		req.SetBasicAuth(c.auth.Username(), pass)
+// timeoutSource returns the source of the time limit err was caused by, or
+// 0 if it wasn't caused by one. The limits of presto and gateways take
+// precedence, since an expiring query context may follow their responses.
+func timeoutSource(err error) TimeoutSource {
+	var se *stmtError
+	if errors.As(err, &se) && se.ErrorName == "EXCEEDED_TIME_LIMIT" {
+		return ServerTimeout
 	}
-	return req, nil
+	var qf *ErrQueryFailed
+	if errors.As(err, &qf) && isGatewayTimeout(qf.StatusCode) {
+		return GatewayTimeout
+	}
+	var pe *ErrProtocol
+	if errors.As(err, &pe) && isGatewayTimeout(pe.StatusCode) {
+		return GatewayTimeout
+	}
+	var qq *ErrQueryQueued
+	if errors.As(err, &qq) || errors.Is(err, context.DeadlineExceeded) {
+		return ClientTimeout
+	}
+	var te *ErrTransport
+	if errors.As(err, &te) && te.Timeout() {
+		return ClientTimeout
+	}
+	return 0
 }
 
-func (c *Conn) roundTrip(ctx context.Context, req *http.Request) (*http.Response, error) {
-	delay := 100 * time.Millisecond
-	const maxDelayBetweenRequests = float64(15 * time.Second)
-	timer := time.NewTimer(0)
-	defer timer.Stop()
-	for {
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		case <-timer.C:
-			timeout := DefaultQueryTimeout
-			if deadline, ok := ctx.Deadline(); ok {
-				timeout = deadline.Sub(time.Now())
-			}
-			client := c.httpClient
-			client.Timeout = timeout
-			resp, err := client.Do(req)
-			if err != nil {
-				return nil, &ErrQueryFailed{Reason: err}
-			}
-			switch resp.StatusCode {
-			case http.StatusOK:
-				if id := resp.Header.Get(prestoStartedTransactionHeader); id != "" {
-					c.httpHeaders.Set(prestoTransactionHeader, id)
-				} else if resp.Header.Get(prestoClearTransactionHeader) == "true" {
-					c.httpHeaders.Del(prestoTransactionHeader)
-				}
-
-				return resp, nil
-			case http.StatusServiceUnavailable:
-				resp.Body.Close()
-				timer.Reset(delay)
-				delay = time.Duration(math.Min(
-					float64(delay)*math.Phi,
-					maxDelayBetweenRequests,
-				))
-				continue
-			default:
-				return nil, newErrQueryFailedFromResponse(resp)
-			}
-		}
-	}
+func isGatewayTimeout(status int) bool {
+	return status == http.StatusGatewayTimeout || status == http.StatusRequestTimeout
 }
 
-// ErrQueryFailed indicates that a query to presto failed.
-type ErrQueryFailed struct {
+// ErrProtocol indicates that presto, or a proxy in front of it, answered a
+// request with a response breaking the client protocol: an unexpected HTTP
+// status, a document that fails to decode, which is an ErrInvalidResponse
+// if it isn't JSON at all, or with the strict_decoding DSN parameter, an
+// unexpected document.
+type ErrProtocol struct {
 	StatusCode int
-	Reason     error
+	Err        error
 }
 
 // Error implements the error interface.
-func (e *ErrQueryFailed) Error() string {
-	return fmt.Sprintf("presto: query failed (%d %s): %q",
-		e.StatusCode, http.StatusText(e.StatusCode), e.Reason)
+func (e *ErrProtocol) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the cause of the error.
+func (e *ErrProtocol) Unwrap() error {
+	return e.Err
+}
+
+// ErrorLocation is the position in the text of a query of the error failing
+// it. Lines and columns start at 1.
+type ErrorLocation struct {
+	Line   int
+	Column int
+}
+
+// ErrorLocationOf returns the location of the error failing a query, for
+// the errors presto reports one for, like syntax errors and unknown
+// columns, e.g. for editors to highlight it. The location is in the text of
+// the query as passed to the driver, but for IN lists rewritten with the
+// in_list_threshold DSN parameter, and for the wrapped queries of SchemaOf
+// and EstimateIO.
+func ErrorLocationOf(err error) (ErrorLocation, bool) {
+	var qf *ErrQueryFailed
+	if !errors.As(err, &qf) {
+		return ErrorLocation{}, false
+	}
+	se, ok := qf.Reason.(*stmtError)
+	if !ok || se.ErrorLocation.LineNumber <= 0 {
+		return ErrorLocation{}, false
+	}
+	return ErrorLocation{Line: se.ErrorLocation.LineNumber, Column: se.ErrorLocation.ColumnNumber}, true
+}
+
+// shiftErrorLocation moves the location of the error failing a query to the
+// left by the given number of columns on its first line, to discount the
+// text the driver prepends to statements.
+func shiftErrorLocation(err error, columns int) {
+	var qf *ErrQueryFailed
+	if columns == 0 || !errors.As(err, &qf) {
+		return
+	}
+	if se, ok := qf.Reason.(*stmtError); ok && se.ErrorLocation.LineNumber == 1 && se.ErrorLocation.ColumnNumber > columns {
+		se.ErrorLocation.ColumnNumber -= columns
+	}
 }
 
 func newErrQueryFailedFromResponse(resp *http.Response) *ErrQueryFailed {
@@ -500,26 +2233,139 @@ func newErrQueryFailedFromResponse(resp *http.Response) *ErrQueryFailed {
 	qf := &ErrQueryFailed{StatusCode: resp.StatusCode}
 	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBytes))
 	if err != nil {
-		qf.Reason = err
+		qf.Reason = &ErrTransport{Err: err}
 		return qf
 	}
 	reason := string(b)
 	if resp.ContentLength > maxBytes {
 		reason += "..."
 	}
-	qf.Reason = errors.New(reason)
+	qf.Reason = &ErrProtocol{StatusCode: resp.StatusCode, Err: errors.New(reason)}
 	return qf
 }
 
+// ErrInvalidResponse indicates that the server returned a response that is
+// not a presto protocol message, such as an HTML error page served by a load
+// balancer or proxy in front of the coordinator.
+type ErrInvalidResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        string // Snippet of the response body
+}
+
+// Error implements the error interface.
+func (e *ErrInvalidResponse) Error() string {
+	return fmt.Sprintf("presto: unexpected non-JSON response (%d %s, Content-Type %q): %q",
+		e.StatusCode, http.StatusText(e.StatusCode), e.ContentType, e.Body)
+}
+
+func newErrInvalidResponse(resp *http.Response, body []byte) *ErrInvalidResponse {
+	const maxSnippet = 512
+	snippet := strings.TrimSpace(string(body))
+	if len(snippet) > maxSnippet {
+		snippet = snippet[:maxSnippet] + "..."
+	}
+	return &ErrInvalidResponse{
+		StatusCode:  resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        snippet,
+	}
+}
+
+// decodeResponse decodes the body of a presto protocol response into v,
+// after passing it to the page observers of ctx and of the connection, and
+// checking it strictly for diagnosis if enabled.
+func (c *Conn) decodeResponse(ctx context.Context, resp *http.Response, v interface{}) error {
+	b, err := ioutil.ReadAll(resp.Body)
+	atomic.AddInt64(&driverStats.bytesFetched, int64(len(b)))
+	if err != nil {
+		return &ErrQueryFailed{StatusCode: resp.StatusCode, Reason: &ErrTransport{Err: err}}
+	}
+	c.observePage(ctx, resp, b)
+	if c.strictDecoding {
+		if !isJSONResponse(resp.Header.Get("Content-Type"), b) {
+			return &ErrProtocol{StatusCode: resp.StatusCode, Err: newErrInvalidResponse(resp, b)}
+		}
+		if err = checkProtocolFields(b); err != nil {
+			return &ErrProtocol{StatusCode: resp.StatusCode, Err: err}
+		}
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err = d.Decode(v); err != nil {
+		if len(b) > 0 && !isJSONResponse(resp.Header.Get("Content-Type"), b) {
+			return &ErrProtocol{StatusCode: resp.StatusCode, Err: newErrInvalidResponse(resp, b)}
+		}
+		return &ErrProtocol{StatusCode: resp.StatusCode, Err: fmt.Errorf("presto: %v", err)}
+	}
+	return nil
+}
+
+// protocolFields are the fields of the responses of the presto client
+// protocol. The driver ignores most of them, so its own types can't detect
+// unexpected fields.
+var protocolFields = map[string]bool{
+	"id":               true,
+	"infoUri":          true,
+	"partialCancelUri": true,
+	"nextUri":          true,
+	"columns":          true,
+	"data":             true,
+	"binaryData":       true,
+	"stats":            true,
+	"error":            true,
+	"warnings":         true,
+	"updateType":       true,
+	"updateCount":      true,
+}
+
+// checkProtocolFields checks that a response is a single JSON object made of
+// the fields of the client protocol, with the ones every response has.
+func checkProtocolFields(b []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return fmt.Errorf("presto: strict decoding: %v", err)
+	}
+	for name := range fields {
+		if !protocolFields[name] {
+			return fmt.Errorf("presto: strict decoding: unexpected field %q in response", name)
+		}
+	}
+	for _, name := range []string{"id", "stats"} {
+		if _, ok := fields[name]; !ok {
+			return fmt.Errorf("presto: strict decoding: missing field %q in response", name)
+		}
+	}
+	return nil
+}
+
+// isJSONResponse reports whether a response body with the given content
+// type looks like a JSON document.
+func isJSONResponse(contentType string, body []byte) bool {
+	if contentType != "" {
+		mt, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mt != "application/json" && !strings.HasSuffix(mt, "+json")) {
+			return false
+		}
+	}
+	b := bytes.TrimSpace(body)
+	return len(b) > 0 && (b[0] == '{' || b[0] == '[')
+}
+
 type driverStmt struct {
 	conn  *Conn
 	query string
 	user  string
+
+	// control is set for transaction control statements, which are exempt
+	// from the deadline policy of the connection.
+	control bool
 }
 
 var (
 	_ driver.Stmt             = &driverStmt{}
 	_ driver.StmtQueryContext = &driverStmt{}
+	_ driver.StmtExecContext  = &driverStmt{}
 )
 
 func (st *driverStmt) Close() error {
@@ -543,25 +2389,121 @@ type stmtResponse struct {
 }
 
 type stmtStats struct {
-	State           string    `json:"state"`
-	Scheduled       bool      `json:"scheduled"`
-	Nodes           int       `json:"nodes"`
-	TotalSplits     int       `json:"totalSplits"`
-	QueuesSplits    int       `json:"queuedSplits"`
-	RunningSplits   int       `json:"runningSplits"`
-	CompletedSplits int       `json:"completedSplits"`
-	UserTimeMillis  int       `json:"userTimeMillis"`
-	CPUTimeMillis   int       `json:"cpuTimeMillis"`
-	WallTimeMillis  int       `json:"wallTimeMillis"`
-	ProcessedRows   int       `json:"processedRows"`
-	ProcessedBytes  int       `json:"processedBytes"`
-	RootStage       stmtStage `json:"rootStage"`
+	State             string    `json:"state"`
+	Scheduled         bool      `json:"scheduled"`
+	Nodes             int       `json:"nodes"`
+	TotalSplits       int       `json:"totalSplits"`
+	QueuesSplits      int       `json:"queuedSplits"`
+	RunningSplits     int       `json:"runningSplits"`
+	CompletedSplits   int       `json:"completedSplits"`
+	UserTimeMillis    int       `json:"userTimeMillis"`
+	CPUTimeMillis     int       `json:"cpuTimeMillis"`
+	WallTimeMillis    int       `json:"wallTimeMillis"`
+	QueuedTimeMillis  int       `json:"queuedTimeMillis"`
+	ElapsedTimeMillis int       `json:"elapsedTimeMillis"`
+	ProcessedRows     int       `json:"processedRows"`
+	ProcessedBytes    int       `json:"processedBytes"`
+	PeakMemoryBytes   int       `json:"peakMemoryBytes"`
+	RootStage         stmtStage `json:"rootStage"`
+
+	// Queued, and the queue position and resource group of queued
+	// queries, are reported by servers that support it.
+	Queued          bool     `json:"queued"`
+	QueuePosition   int      `json:"queuePosition"`
+	ResourceGroupID []string `json:"resourceGroupId"`
+}
+
+// QueryStats contains the resource usage of a query, as reported by presto.
+type QueryStats struct {
+	QueryID         string
+	State           string
+	ElapsedTime     time.Duration
+	QueuedTime      time.Duration
+	CPUTime         time.Duration
+	WallTime        time.Duration
+	PeakMemoryBytes int64
+	ProcessedRows   int64
+	ProcessedBytes  int64
+}
+
+func newQueryStats(id string, s stmtStats) *QueryStats {
+	return &QueryStats{
+		QueryID:         id,
+		State:           s.State,
+		ElapsedTime:     time.Duration(s.ElapsedTimeMillis) * time.Millisecond,
+		QueuedTime:      time.Duration(s.QueuedTimeMillis) * time.Millisecond,
+		CPUTime:         time.Duration(s.CPUTimeMillis) * time.Millisecond,
+		WallTime:        time.Duration(s.WallTimeMillis) * time.Millisecond,
+		PeakMemoryBytes: int64(s.PeakMemoryBytes),
+		ProcessedRows:   int64(s.ProcessedRows),
+		ProcessedBytes:  int64(s.ProcessedBytes),
+	}
+}
+
+// QueryProgress is the progress of a query, as reported by presto in every
+// response while it runs.
+type QueryProgress struct {
+	QueryID         string
+	State           string
+	Queued          bool
+	QueuedTime      time.Duration
+	ElapsedTime     time.Duration
+	TotalSplits     int
+	CompletedSplits int
+
+	// QueuePosition is the position of a queued query in the queue of its
+	// resource group, starting at 1, and ResourceGroup the path of the
+	// resource group, e.g. ["global", "adhoc"]. They are zero when the
+	// server doesn't report them.
+	QueuePosition int
+	ResourceGroup []string
+}
+
+func newQueryProgress(id string, s stmtStats) QueryProgress {
+	return QueryProgress{
+		QueryID:         id,
+		State:           s.State,
+		Queued:          s.Queued || s.State == "QUEUED",
+		QueuedTime:      time.Duration(s.QueuedTimeMillis) * time.Millisecond,
+		ElapsedTime:     time.Duration(s.ElapsedTimeMillis) * time.Millisecond,
+		TotalSplits:     s.TotalSplits,
+		CompletedSplits: s.CompletedSplits,
+		QueuePosition:   s.QueuePosition,
+		ResourceGroup:   s.ResourceGroupID,
+	}
+}
+
+// TypeSignatureRows is implemented by the rows returned by this driver.
+// ColumnTypeSignature returns the type signature of a column as sent by
+// presto, e.g. {"rawType":"array","typeArguments":[...],...}, for bespoke
+// decoding of types the driver does not cover.
+type TypeSignatureRows interface {
+	ColumnTypeSignature(index int) json.RawMessage
+}
+
+// StatsRows is implemented by the rows returned by this driver. FinalStats
+// returns the statistics sent by presto with the last page of results, and
+// reports false until the rows are exhausted. The rows are reachable by
+// querying the driver connection of sql.Conn.Raw; with database/sql rows,
+// the same statistics are carried by the *EOF error returned by Rows.Err.
+type StatsRows interface {
+	FinalStats() (*QueryStats, bool)
+}
+
+// HeaderRows is implemented by the rows returned by this driver.
+// ResponseHeader returns the HTTP headers of the last protocol response
+// received for the query, where gateways and security layers add metadata
+// such as the cluster that ran it or an audit ID. The rows are reachable
+// by querying the driver connection of sql.Conn.Raw.
+type HeaderRows interface {
+	ResponseHeader() http.Header
 }
 
 type stmtError struct {
 	Message       string               `json:"message"`
 	ErrorName     string               `json:"errorName"`
 	ErrorCode     int                  `json:"errorCode"`
+	ErrorType     string               `json:"errorType"`
 	ErrorLocation stmtErrorLocation    `json:"errorLocation"`
 	FailureInfo   stmtErrorFailureInfo `json:"failureInfo"`
 	// Other fields omitted
@@ -599,8 +2541,10 @@ type stmtStage struct {
 }
 
 // EOF indicates the server has returned io.EOF for the given QueryID.
+// Stats holds the final statistics of the query.
 type EOF struct {
 	QueryID string
+	Stats   *QueryStats
 }
 
 // Error implements the error interface.
@@ -613,13 +2557,28 @@ func (st *driverStmt) Query(args []driver.Value) (driver.Rows, error) {
 }
 
 func (st *driverStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
-	query := st.query
+	if _, ok := ctx.Deadline(); !ok && st.conn.requireDeadline && !st.control {
+		return nil, ErrNoDeadline
+	}
+	text := st.query
+	if n := st.conn.inListThreshold; n > 0 {
+		text = rewriteInLists(text, n)
+	}
+	query := text
 	var hs http.Header
+	var params []string
+	immediate, literal := false, "" // literal is the statement EXECUTE IMMEDIATE runs
 
 	if len(args) > 0 {
 		hs = make(http.Header)
 		var ss []string
+		var values []interface{}
 		for _, arg := range args {
+			if st.conn.describeInput && arg.Name != prestoUserHeader && arg.Name != prestoClientTagsHeader && arg.Name != prestoClientInfoHeader {
+				// Serialized once the types of the parameters are known.
+				values = append(values, arg.Value)
+				continue
+			}
 			s, err := Serial(arg.Value)
 			if err != nil {
 				return nil, err
@@ -635,31 +2594,282 @@ func (st *driverStmt) QueryContext(ctx context.Context, args []driver.NamedValue
 				ss = append(ss, s)
 			}
 		}
+		if len(values) > 0 {
+			types, err := st.conn.inputTypes(ctx, text, hs)
+			if err != nil {
+				return nil, err
+			}
+			if ss, err = coerceArgs(values, types); err != nil {
+				return nil, err
+			}
+		}
 
 		if len(ss) > 0 {
-			if hs.Get(preparedStatementHeader) == "" {
-				hs.Add(preparedStatementHeader, preparedStatementName+"="+url.QueryEscape(st.query))
+			params = ss
+			if st.conn.executeImmediate {
+				immediate = true
+				literal, _ = Serial(text)
+				query = executeImmediatePrefix + literal + " USING " + strings.Join(ss, ", ")
+			} else {
+				query = st.prepare(hs, text, params)
+			}
+		}
+	}
+
+	hs = contextHeaders(ctx, hs)
+	if err := st.conn.labelHeaders(ctx, hs); err != nil {
+		return nil, err
+	}
+	if user := hs.Get(prestoUserHeader); user != "" {
+		// The user of the query context is sent with all its requests,
+		// like the one of the arguments.
+		st.user = user
+	}
+	if st.conn.traceTokenFunc != nil && hs.Get(prestoTraceTokenHeader) == "" {
+		if token := st.conn.traceTokenFunc(ctx); token != "" {
+			hs.Set(prestoTraceTokenHeader, token)
+		}
+	}
+	if st.conn.traceToken != "" && hs.Get(prestoTraceTokenHeader) == "" {
+		hs.Set(prestoTraceTokenHeader, st.conn.traceToken)
+	}
+	var token string
+	if st.conn.submissionRetries > 0 {
+		token = newRequestID()
+	}
+	annotation := st.conn.annotation(hs)
+	if err := st.conn.checkStatementSize(annotation + query + submissionComment(token)); err != nil {
+		return nil, err
+	}
+	// release cancels the contexts derived for the query once it ends.
+	var release context.CancelFunc
+	if d := st.conn.defaultDeadline; d > 0 && !st.control {
+		if _, ok := ctx.Deadline(); !ok {
+			ctx, release = context.WithTimeout(ctx, d)
+		}
+	}
+	if st.conn.retryBudget > 0 || st.conn.retryBudgetTime > 0 {
+		ctx = context.WithValue(ctx, retryBudgetKey, &retryBudget{maxRetries: st.conn.retryBudget, maxWait: st.conn.retryBudgetTime})
+	}
+	ctx = withAuthState(ctx)
+	started := queryStarted(ctx)
+	var handle *QueryHandle
+	if started != nil {
+		handle = &QueryHandle{}
+		ctx, handle.cancel = context.WithCancel(ctx)
+		release = joinCancel(handle.cancel, release)
+	}
+	atomic.AddInt64(&driverStats.queries, 1)
+	submitted := time.Now()
+	queued, queueFull, resources, resubmissions := 0, 0, 0, 0
+	for {
+		rows, err := st.submit(ctx, annotation+query+submissionComment(token), hs)
+		if err == nil {
+			atomic.AddInt64(&driverStats.openCursors, 1)
+			rows.submitted = submitted
+			rows.resourceRetries = resources
+			rows.release = release
+			if handle != nil {
+				handle.QueryID = rows.id
+				started(handle)
+			}
+			return rows, nil
+		}
+		var qe *ErrQueryQueued
+		if errors.As(err, &qe) && queued < st.conn.queuedRetries {
+			if err = spendRetryBudget(ctx, 0, err); err == nil {
+				queued++
+				continue
+			}
+		}
+		if isQueueFull(err) && queueFull < st.conn.queueFullRetries {
+			if err = spendRetryBudget(ctx, backoff(minQueueFullBackoff, maxQueueFullBackoff, queueFull), err); err == nil {
+				if err = st.conn.queue.hold(ctx, queueFull, err); err == nil {
+					queueFull++
+					continue
+				}
+			}
+		}
+		if token != "" && isAmbiguousSubmission(ctx, err) && resubmissions < st.conn.submissionRetries {
+			if err = st.conn.checkSubmission(ctx, token, err); err == nil {
+				if err = spendRetryBudget(ctx, 0, err); err == nil {
+					resubmissions++
+					continue
+				}
 			}
-			query = "EXECUTE " + preparedStatementName + " USING " + strings.Join(ss, ", ")
 		}
+		if isInsufficientResources(err) && resources < st.conn.resourceRetries {
+			wait := backoff(st.conn.resourceBackoff, maxResourceRetryBackoff, resources)
+			if err = spendRetryBudget(ctx, wait, err); err == nil {
+				if err = sleepBackoff(ctx, st.conn.resourceBackoff, maxResourceRetryBackoff, resources); err == nil {
+					resources++
+					continue
+				}
+			}
+		}
+		if immediate && isExecuteImmediateUnsupported(err, literal, utf8.RuneCountInString(annotation)) {
+			st.conn.executeImmediate, immediate = false, false
+			query = st.prepare(hs, text, params)
+			if err = st.conn.checkStatementSize(annotation + query + submissionComment(token)); err == nil {
+				continue
+			}
+		}
+		if release != nil {
+			release()
+		}
+		shiftErrorLocation(err, utf8.RuneCountInString(annotation))
+		err = classifyTimeout(err)
+		endQuery(QueryEnd{SQLHash: sqlHash(st.query), Duration: time.Since(submitted), Err: err})
+		return nil, err
+	}
+}
+
+// checkStatementSize returns an ErrStatementTooLarge if the text of a
+// statement, as submitted, exceeds the max_statement_size of the connection.
+func (c *Conn) checkStatementSize(stmt string) error {
+	if limit := c.maxStatementSize; limit > 0 && len(stmt) > limit {
+		return &ErrStatementTooLarge{Size: len(stmt), Limit: limit}
+	}
+	return nil
+}
+
+// annotation returns the comment prepended to a statement with the given
+// headers, with the annotations of the connection and the trace token of
+// the query, empty if annotations are disabled. The comment ends on the
+// first line, so that only the error locations on the first line move.
+func (c *Conn) annotation(hs http.Header) string {
+	if c.annotations == "" {
+		return ""
+	}
+	a := c.annotations
+	if token := hs.Get(prestoTraceTokenHeader); token != "" && !strings.Contains(token, "*/") && !strings.ContainsAny(token, "\r\n") {
+		a += ", trace_token=" + token
+	}
+	return "/* " + a + " */ "
+}
+
+// prepare sends the statement text in the prepared statement header of hs,
+// and returns the query executing it with the given parameters.
+func (st *driverStmt) prepare(hs http.Header, text string, params []string) string {
+	hs.Set(preparedStatementHeader, st.conn.preparedStatementName+"="+url.QueryEscape(text))
+	return "EXECUTE " + st.conn.preparedStatementName + " USING " + strings.Join(params, ", ")
+}
+
+const executeImmediatePrefix = "EXECUTE IMMEDIATE "
+
+// isExecuteImmediateUnsupported reports whether the EXECUTE IMMEDIATE query
+// failed because the server does not support it, rather than for an error
+// in the statement it executes: the syntax error names IMMEDIATE, or is
+// the one of older servers, which parse IMMEDIATE as the name of a prepared
+// statement and fail on the statement literal following it, which starts
+// offset characters further in the first line.
+func isExecuteImmediateUnsupported(err error, literal string, offset int) bool {
+	var qf *ErrQueryFailed
+	if !errors.As(err, &qf) {
+		return false
+	}
+	se, ok := qf.Reason.(*stmtError)
+	if !ok || se.ErrorName != "SYNTAX_ERROR" || se.ErrorLocation.LineNumber != 1 {
+		return false
+	}
+	if strings.Contains(strings.ToUpper(se.Message), "IMMEDIATE") {
+		return true
+	}
+	// The message quotes the offending input, the statement literal.
+	if len(literal) > 16 {
+		literal = literal[:16]
+	}
+	return se.ErrorLocation.ColumnNumber == offset+len(executeImmediatePrefix)+1 &&
+		strings.Contains(se.Message, "input '"+literal)
+}
+
+// exec runs a transaction control statement, which completes with its first
+// results, leaving them unfetched.
+func (st *driverStmt) exec(ctx context.Context) error {
+	st.control = true
+	rows, err := st.QueryContext(ctx, []driver.NamedValue{})
+	if err != nil {
+		return err
+	}
+	rows.(*driverRows).end()
+	return nil
+}
+
+// postStatement sends the query to a coordinator. If it fails with a gateway
+// error, no query was created yet, so the query is sent to the next
+// coordinator, unless the connection runs a transaction tied to the first.
+func (c *Conn) postStatement(ctx context.Context, query string, hs http.Header) (*http.Response, error) {
+	baseURL := c.coordinatorURL(ctx)
+	for tried := 1; ; tried++ {
+		resp, err := c.postStatementTo(ctx, baseURL, query, hs)
+		var qf *ErrQueryFailed
+		if !errors.As(err, &qf) || !isGatewayError(qf.StatusCode) ||
+			tried >= len(c.coordinators) || c.inTransaction(ctx) {
+			return resp, err
+		}
+		atomic.AddInt64(&driverStats.failovers, 1)
+		baseURL = c.nextCoordinatorURL(baseURL)
 	}
+}
 
-	req, err := st.conn.newRequest("POST", st.conn.baseURL+"/v1/statement", strings.NewReader(query), hs)
+// postStatementTo sends the query to the coordinator at baseURL, gzip
+// encoded if it exceeds the compression threshold. Servers that reject the
+// encoding get the plain query, and compression is disabled for the rest of
+// the connection.
+func (c *Conn) postStatementTo(ctx context.Context, baseURL, query string, hs http.Header) (*http.Response, error) {
+	compress := c.compressThreshold > 0 && len(query) >= c.compressThreshold
+	var body io.Reader = strings.NewReader(query)
+	if compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := io.WriteString(zw, query); err != nil {
+			return nil, fmt.Errorf("presto: %v", err)
+		}
+		if err := zw.Close(); err != nil {
+			return nil, fmt.Errorf("presto: %v", err)
+		}
+		body = &buf
+	}
+	req, err := c.newRequest("POST", baseURL+"/v1/statement", body, hs)
 	if err != nil {
 		return nil, err
 	}
+	if compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := c.roundTrip(ctx, req)
+	var qf *ErrQueryFailed
+	if compress && errors.As(err, &qf) && rejectsEncoding(qf.StatusCode) {
+		c.compressThreshold = 0
+		return c.postStatementTo(ctx, baseURL, query, hs)
+	}
+	return resp, err
+}
+
+// rejectsEncoding reports whether a response status to a gzip encoded
+// statement may reject the encoding: 415 Unsupported Media Type is the
+// status meant for it, but servers and gateways unaware of the encoding
+// also answer 400 Bad Request, failing to read the statement, or 501 Not
+// Implemented.
+func rejectsEncoding(status int) bool {
+	switch status {
+	case http.StatusBadRequest, http.StatusUnsupportedMediaType, http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
 
-	resp, err := st.conn.roundTrip(ctx, req)
+// submit posts the query to presto and fetches the first page of results.
+func (st *driverStmt) submit(ctx context.Context, query string, hs http.Header) (*driverRows, error) {
+	resp, err := st.conn.postStatement(ctx, query, hs)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 	var sr stmtResponse
-	d := json.NewDecoder(resp.Body)
-	d.UseNumber()
-	err = d.Decode(&sr)
+	err = st.conn.decodeResponse(ctx, resp, &sr)
 	if err != nil {
-		return nil, fmt.Errorf("presto: %v", err)
+		return nil, err
 	}
 	err = handleResponseError(resp.StatusCode, sr.Error)
 	if err != nil {
@@ -670,30 +2880,45 @@ func (st *driverStmt) QueryContext(ctx context.Context, args []driver.NamedValue
 		stmt:    st,
 		nextURI: sr.NextURI,
 		id:      sr.ID,
+		stats:   sr.Stats,
+		started: time.Now(),
+		query:   query,
+		headers: hs,
+		retries: idempotentRetries(ctx),
+
+		respHeader: resp.Header,
+	}
+	if progress := progressFunc(ctx); progress != nil {
+		progress(newQueryProgress(sr.ID, sr.Stats))
 	}
 	completedChannel := make(chan struct{})
 	defer close(completedChannel)
+	deleted := make(chan error, 1)
 	go func() {
 		select {
 		case <-ctx.Done():
-			err := rows.Close()
-			if err != nil {
-				return
-			}
+			deleted <- rows.deleteQuery()
 		case <-completedChannel:
 			return
 		}
 	}()
 	if err = rows.fetch(false); err != nil {
+		if cause := ctx.Err(); cause != nil {
+			// The context ending is bound to be seen by the goroutine
+			// deleting the query, as the completed channel is still open.
+			return nil, &ErrQueryAbandoned{QueryID: rows.id, Acknowledged: <-deleted == nil, Reason: cause}
+		}
 		return nil, err
 	}
 	return rows, nil
 }
 
 type rowsColumn struct {
-	name   string
-	dbType string
-	vc     driver.ValueConverter
+	name          string
+	dbType        string
+	typeSignature json.RawMessage
+	vc            driver.ValueConverter
+	scanType      reflect.Type
 }
 
 type driverRows struct {
@@ -706,35 +2931,148 @@ type driverRows struct {
 	rowindex int
 	columns  []rowsColumn
 	data     []queryData
+	stats    stmtStats
+	started  time.Time
+
+	// respHeader holds the headers of the last protocol response.
+	respHeader http.Header
+
+	// query, headers and retries allow re-executing idempotent queries,
+	// skipping the rows already delivered.
+	query     string
+	headers   http.Header
+	retries   int
+	delivered int
+
+	// resourceRetries counts the resubmissions of the query after failing
+	// for lack of resources, allowed until it delivers rows.
+	resourceRetries int
+
+	// bytes is the buffer of the values delivered as []byte, reset for
+	// every row, nil unless the query runs with WithRawBytes.
+	bytes *[]byte
+
+	// submitted is when the query was first submitted, and ended whether
+	// its end was recorded, for the driver statistics.
+	submitted time.Time
+	ended     bool
+
+	// release cancels the context derived for a query handle, nil if none.
+	release context.CancelFunc
+
+	// emptyPages counts the consecutive empty pages in emptyState, to back
+	// off polling.
+	emptyPages int
+	emptyState string
+
+	// updateType and updateCount are the type of the update of the
+	// statement, e.g. INSERT, and the number of rows it changed, as last
+	// reported by presto.
+	updateType  string
+	updateCount *int64
+}
+
+var (
+	_ driver.Rows                           = &driverRows{}
+	_ driver.RowsColumnTypeDatabaseTypeName = &driverRows{}
+	_ driver.RowsColumnTypeLength           = &driverRows{}
+	_ driver.RowsColumnTypePrecisionScale   = &driverRows{}
+	_ StatsRows                             = &driverRows{}
+	_ HeaderRows                            = &driverRows{}
+	_ TypeSignatureRows                     = &driverRows{}
+)
+
+// ResponseHeader implements the HeaderRows interface.
+func (qr *driverRows) ResponseHeader() http.Header {
+	return qr.respHeader
+}
+
+// FinalStats implements the StatsRows interface.
+func (qr *driverRows) FinalStats() (*QueryStats, bool) {
+	if qr.err != io.EOF {
+		return nil, false
+	}
+	return newQueryStats(qr.id, qr.stats), true
 }
 
-var _ driver.Rows = &driverRows{}
+func (qr *driverRows) eof() error {
+	return &EOF{QueryID: qr.id, Stats: newQueryStats(qr.id, qr.stats)}
+}
 
 func (qr *driverRows) Close() error {
-	if qr.nextURI != "" {
-		hs := make(http.Header)
+	err := qr.cancel()
+	qr.end()
+	return err
+}
+
+// cancel deletes the query in presto if its results were not fully fetched.
+func (qr *driverRows) cancel() error {
+	if err := qr.deleteQuery(); err != nil {
+		return err
+	}
+	return qr.err
+}
+
+// abandon deletes the query in presto after its context ended, if its
+// results were not fully fetched, and returns the error reporting it.
+func (qr *driverRows) abandon(cause error) error {
+	if qr.nextURI == "" {
+		return cause
+	}
+	err := qr.deleteQuery()
+	return &ErrQueryAbandoned{QueryID: qr.id, Acknowledged: err == nil, Reason: cause}
+}
+
+// deleteQuery deletes the query in presto if its results were not fully
+// fetched, with a deadline independent of the context of the query, and
+// returns nil once presto acknowledged it.
+func (qr *driverRows) deleteQuery() error {
+	if qr.nextURI == "" {
+		return nil
+	}
+	hs := make(http.Header)
+	if qr.stmt.user != "" {
 		hs.Add(prestoUserHeader, qr.stmt.user)
-		req, err := qr.stmt.conn.newRequest("DELETE", qr.nextURI, nil, hs)
-		if err != nil {
-			return err
-		}
-		ctx, cancel := context.WithDeadline(
-			context.Background(),
-			time.Now().Add(DefaultCancelQueryTimeout),
-		)
-		defer cancel()
-		resp, err := qr.stmt.conn.roundTrip(ctx, req)
-		if err != nil {
-			qferr, ok := err.(*ErrQueryFailed)
-			if ok && qferr.StatusCode == http.StatusNoContent {
-				qr.nextURI = ""
-				return nil
-			}
-			return err
+	}
+	req, err := qr.stmt.conn.newRequest("DELETE", qr.nextURI, nil, hs)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithDeadline(
+		context.Background(),
+		time.Now().Add(DefaultCancelQueryTimeout),
+	)
+	defer cancel()
+	resp, err := qr.stmt.conn.roundTrip(ctx, req)
+	if err != nil {
+		qferr, ok := err.(*ErrQueryFailed)
+		if ok && qferr.StatusCode == http.StatusNoContent {
+			qr.nextURI = ""
+			return nil
 		}
-		resp.Body.Close()
+		return err
 	}
-	return qr.err
+	resp.Body.Close()
+	qr.nextURI = ""
+	return nil
+}
+
+// end records the end of the query in the driver statistics and the query
+// hooks, once.
+func (qr *driverRows) end() {
+	if qr.ended {
+		return
+	}
+	qr.ended = true
+	if qr.release != nil {
+		qr.release()
+	}
+	atomic.AddInt64(&driverStats.openCursors, -1)
+	err := qr.err
+	if err == io.EOF || err == sql.ErrNoRows {
+		err = nil
+	}
+	endQuery(QueryEnd{QueryID: qr.id, SQLHash: sqlHash(qr.stmt.query), Duration: time.Since(qr.submitted), Err: err})
 }
 
 func (qr *driverRows) Columns() []string {
@@ -754,37 +3092,100 @@ func (qr *driverRows) Columns() []string {
 	return res
 }
 
-var coltypeLengthSuffix = regexp.MustCompile(`\(\d+\)$`)
+var (
+	coltypeLengthSuffix = regexp.MustCompile(`\(\d+\)$`)
+	coltypeDecimal      = regexp.MustCompile(`^decimal\((\d+),\s*(\d+)\)$`)
+)
 
+// ColumnTypeDatabaseTypeName implements the
+// driver.RowsColumnTypeDatabaseTypeName interface, with the full presto type
+// of the column, e.g. varchar(10), timestamp(3) or array(bigint).
 func (qr *driverRows) ColumnTypeDatabaseTypeName(index int) string {
+	return qr.columns[index].dbType
+}
+
+// ColumnTypeLength implements the driver.RowsColumnTypeLength interface,
+// with the length of char(n) and varchar(n) columns, and math.MaxInt64 for
+// unbounded varchar, varbinary and json columns.
+func (qr *driverRows) ColumnTypeLength(index int) (int64, bool) {
 	name := qr.columns[index].dbType
-	if m := coltypeLengthSuffix.FindStringSubmatch(name); m != nil {
-		name = name[0 : len(name)-len(m[0])]
+	if strings.HasPrefix(name, "char(") || strings.HasPrefix(name, "varchar(") {
+		if m := coltypeLengthSuffix.FindString(name); m != "" {
+			n, err := strconv.ParseInt(m[1:len(m)-1], 10, 64)
+			return n, err == nil
+		}
 	}
-	return name
+	switch name {
+	case "varchar", "varbinary", "json":
+		return math.MaxInt64, true
+	}
+	return 0, false
+}
+
+// ColumnTypePrecisionScale implements the
+// driver.RowsColumnTypePrecisionScale interface, with the precision and
+// scale of decimal(p,s) columns.
+func (qr *driverRows) ColumnTypePrecisionScale(index int) (int64, int64, bool) {
+	m := coltypeDecimal.FindStringSubmatch(qr.columns[index].dbType)
+	if m == nil {
+		return 0, 0, false
+	}
+	precision, _ := strconv.ParseInt(m[1], 10, 64)
+	scale, _ := strconv.ParseInt(m[2], 10, 64)
+	return precision, scale, true
+}
+
+// ColumnTypeScanType implements the driver.RowsColumnTypeScanType
+// interface. Integer columns report the Go type of their width, e.g. int8
+// for tinyint, which their values are delivered as with the sized_integers
+// DSN parameter, and which they scan into in any case.
+func (qr *driverRows) ColumnTypeScanType(index int) reflect.Type {
+	return qr.columns[index].scanType
+}
+
+// ColumnTypeSignature implements the TypeSignatureRows interface.
+func (qr *driverRows) ColumnTypeSignature(index int) json.RawMessage {
+	return qr.columns[index].typeSignature
 }
 
 func (qr *driverRows) Next(dest []driver.Value) error {
 	if qr.err != nil {
 		return qr.err
 	}
+	// Stop delivering buffered rows as soon as the query is cancelled,
+	// rather than at the next page.
+	if err := qr.ctx.Err(); err != nil {
+		qr.err = classifyTimeout(qr.abandon(err))
+		return qr.err
+	}
 	if qr.columns == nil || qr.rowindex >= len(qr.data) {
 		if qr.nextURI == "" {
 			qr.err = io.EOF
-			return &EOF{QueryID: qr.id}
+			return qr.eof()
 		}
 		if err := qr.fetch(true); err != nil {
-			qr.err = err
-			if qr.err == io.EOF {
-				return &EOF{QueryID: qr.id}
+			if err != io.EOF {
+				err = qr.reexecute(err)
+			}
+			if cause := qr.ctx.Err(); err != nil && err != io.EOF && cause != nil {
+				err = qr.abandon(cause)
+			}
+			if err != nil {
+				qr.err = classifyTimeout(err)
+				if qr.err == io.EOF {
+					return qr.eof()
+				}
+				return qr.err
 			}
-			return qr.err
 		}
 	}
 	if len(qr.columns) == 0 {
 		qr.err = sql.ErrNoRows
 		return qr.err
 	}
+	if qr.bytes != nil {
+		*qr.bytes = (*qr.bytes)[:0]
+	}
 	for i, v := range qr.columns {
 		vv, err := v.vc.ConvertValue(qr.data[qr.rowindex][i])
 		if err != nil {
@@ -794,9 +3195,132 @@ func (qr *driverRows) Next(dest []driver.Value) error {
 		dest[i] = vv
 	}
 	qr.rowindex++
+	qr.delivered++
 	return nil
 }
 
+// reexecute resubmits an idempotent query after fetching its results failed
+// with cause, and skips the rows that were already delivered. Any query
+// that failed for lack of resources before delivering rows is resubmitted
+// too, after a backoff, if the connection allows it. It returns io.EOF if
+// the new result ends with the delivered rows.
+func (qr *driverRows) reexecute(cause error) error {
+	for {
+		conn := qr.stmt.conn
+		if qr.delivered == 0 && isInsufficientResources(cause) && qr.resourceRetries < conn.resourceRetries {
+			wait := backoff(conn.resourceBackoff, maxResourceRetryBackoff, qr.resourceRetries)
+			if err := spendRetryBudget(qr.ctx, wait, cause); err != nil {
+				return err
+			}
+			if err := sleepBackoff(qr.ctx, conn.resourceBackoff, maxResourceRetryBackoff, qr.resourceRetries); err != nil {
+				return err
+			}
+			qr.resourceRetries++
+		} else if qr.retries > 0 && isReexecutable(qr.ctx, cause) {
+			if qr.delivered > 0 && exactlyOnce(qr.ctx) {
+				return &ErrRowsDelivered{QueryID: qr.id, Rows: qr.delivered, Reason: cause}
+			}
+			if err := spendRetryBudget(qr.ctx, 0, cause); err != nil {
+				return err
+			}
+			qr.retries--
+			atomic.AddInt64(&driverStats.reexecutions, 1)
+		} else {
+			return cause
+		}
+		qr.cancel()
+		rows, err := qr.stmt.submit(qr.ctx, qr.query, qr.headers)
+		if err == nil {
+			err = rows.skip(qr.delivered)
+		}
+		if err != nil && err != io.EOF {
+			var sr *ErrShortResult
+			if errors.As(err, &sr) {
+				return err
+			}
+			cause = err
+			continue
+		}
+		rows.retries = qr.retries
+		rows.resourceRetries = qr.resourceRetries
+		rows.delivered = qr.delivered
+		rows.submitted = qr.submitted
+		rows.release = qr.release
+		*qr = *rows
+		return err
+	}
+}
+
+// isReexecutable reports whether a query that failed with err may be
+// re-executed.
+func isReexecutable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || err == ErrQueryCancelled {
+		return false
+	}
+	var qf *ErrQueryFailed
+	return !errors.As(err, &qf) || qf.ErrorType != ErrorTypeUser
+}
+
+// ErrRowsDelivered indicates that a query run with WithExactlyOnce failed
+// after delivering rows, so it was not re-executed, which could deliver
+// them twice. Rows is the number of rows delivered, and Reason the failure.
+type ErrRowsDelivered struct {
+	QueryID string
+	Rows    int
+	Reason  error
+}
+
+// Error implements the error interface.
+func (e *ErrRowsDelivered) Error() string {
+	return fmt.Sprintf("presto: query %s failed after delivering %d rows, not re-executed: %v", e.QueryID, e.Rows, e.Reason)
+}
+
+// Unwrap returns the failure of the query.
+func (e *ErrRowsDelivered) Unwrap() error {
+	return e.Reason
+}
+
+// ErrShortResult indicates that a query re-executed with
+// WithIdempotentRetries returned fewer rows than the failed execution had
+// delivered, so the result changed between them and the remaining rows
+// could not be delivered. Rows is the number of rows delivered.
+type ErrShortResult struct {
+	QueryID string
+	Rows    int
+}
+
+// Error implements the error interface.
+func (e *ErrShortResult) Error() string {
+	return fmt.Sprintf("presto: re-executed query %s returned fewer than the %d rows already delivered", e.QueryID, e.Rows)
+}
+
+// skip discards the first n rows of the result, fetching pages as needed.
+func (qr *driverRows) skip(n int) error {
+	rows := n
+	for {
+		k := len(qr.data) - qr.rowindex
+		if k > n {
+			qr.rowindex += n
+			return nil
+		}
+		qr.rowindex += k
+		n -= k
+		if qr.nextURI == "" {
+			break
+		}
+		if err := qr.fetch(true); err != nil {
+			if err != io.EOF {
+				return err
+			}
+			break
+		}
+	}
+	if n > 0 {
+		return &ErrShortResult{QueryID: qr.id, Rows: rows}
+	}
+	return io.EOF
+}
+
 type queryResponse struct {
 	ID               string        `json:"id"`
 	InfoURI          string        `json:"infoUri"`
@@ -806,12 +3330,37 @@ type queryResponse struct {
 	Data             []queryData   `json:"data"`
 	Stats            stmtStats     `json:"stats"`
 	Error            stmtError     `json:"error"`
+	UpdateType       string        `json:"updateType"`
+	UpdateCount      *int64        `json:"updateCount"`
+
+	// segments holds the data of servers using the spooled protocol.
+	segments *segmentedData
 }
 
 type queryColumn struct {
 	Name          string        `json:"name"`
 	Type          string        `json:"type"`
 	TypeSignature typeSignature `json:"typeSignature"`
+
+	rawTypeSignature json.RawMessage
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, retaining the
+// type signature as sent by the server.
+func (c *queryColumn) UnmarshalJSON(b []byte) error {
+	type column queryColumn
+	v := struct {
+		*column
+		TypeSignature json.RawMessage `json:"typeSignature"`
+	}{column: (*column)(c)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	c.rawTypeSignature = v.TypeSignature
+	if len(v.TypeSignature) == 0 {
+		return nil
+	}
+	return json.Unmarshal(v.TypeSignature, &c.TypeSignature)
 }
 
 type queryData []interface{}
@@ -836,6 +3385,7 @@ func handleResponseError(status int, respErr stmtError) error {
 	default:
 		return &ErrQueryFailed{
 			StatusCode: status,
+			ErrorType:  ErrorType(respErr.ErrorType),
 			Reason:     &respErr,
 		}
 	}
@@ -843,7 +3393,9 @@ func handleResponseError(status int, respErr stmtError) error {
 
 func (qr *driverRows) fetch(allowEOF bool) error {
 	hs := make(http.Header)
-	hs.Add(prestoUserHeader, qr.stmt.user)
+	if qr.stmt.user != "" {
+		hs.Add(prestoUserHeader, qr.stmt.user)
+	}
 	req, err := qr.stmt.conn.newRequest("GET", qr.nextURI, nil, hs)
 	if err != nil {
 		return err
@@ -854,26 +3406,51 @@ func (qr *driverRows) fetch(allowEOF bool) error {
 	}
 	defer resp.Body.Close()
 	var qresp queryResponse
-	d := json.NewDecoder(resp.Body)
-	d.UseNumber()
-	err = d.Decode(&qresp)
+	err = qr.stmt.conn.decodeResponse(qr.ctx, resp, &qresp)
 	if err != nil {
-		return fmt.Errorf("presto: %v", err)
+		return err
 	}
 	err = handleResponseError(resp.StatusCode, qresp.Error)
 	if err != nil {
 		return err
 	}
+	if qresp.segments != nil {
+		if qresp.Data, err = qr.loadSegments(qresp.segments); err != nil {
+			return err
+		}
+	}
 	qr.rowindex = 0
+	qr.respHeader = resp.Header
 	qr.data = qresp.Data
 	qr.nextURI = qresp.NextURI
+	qr.stats = qresp.Stats
+	if qresp.UpdateType != "" {
+		qr.updateType = qresp.UpdateType
+	}
+	if qresp.UpdateCount != nil {
+		qr.updateCount = qresp.UpdateCount
+	}
+	if progress := progressFunc(qr.ctx); progress != nil {
+		progress(newQueryProgress(qr.id, qresp.Stats))
+	}
+	if limit := qr.stmt.conn.maxQueuedTime; limit > 0 && qresp.Stats.State == "QUEUED" {
+		if queued := time.Since(qr.started); queued > limit {
+			qr.cancel()
+			return &ErrQueryQueued{QueryID: qr.id, QueuedTime: queued}
+		}
+	}
 	if len(qr.data) == 0 {
 		if qr.nextURI != "" {
+			if err := qr.backoff(qresp.Stats.State); err != nil {
+				return err
+			}
 			return qr.fetch(allowEOF)
 		}
 		if allowEOF {
 			return io.EOF
 		}
+	} else {
+		qr.emptyPages = 0
 	}
 	if qr.columns == nil && len(qresp.Columns) > 0 {
 		return qr.initColumns(&qresp)
@@ -881,31 +3458,99 @@ func (qr *driverRows) fetch(allowEOF bool) error {
 	return nil
 }
 
+// backoff waits before polling for the next page after an empty one in the
+// given state, exponentially longer while the query stays in that state.
+func (qr *driverRows) backoff(state string) error {
+	if state != qr.emptyState {
+		qr.emptyState, qr.emptyPages = state, 0
+	}
+	qr.emptyPages++
+	if qr.emptyPages < 2 {
+		return nil
+	}
+	c := qr.stmt.conn
+	delay := c.emptyPageMaxBackoff
+	if n := qr.emptyPages - 2; n < 16 && c.emptyPageMinBackoff<<uint(n) < delay {
+		delay = c.emptyPageMinBackoff << uint(n)
+	}
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-qr.ctx.Done():
+		return qr.ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
 func (qr *driverRows) initColumns(resp *queryResponse) error {
 	qr.columns = make([]rowsColumn, len(resp.Columns))
+	raw := rawValues(qr.ctx)
+	streams := binaryStreams(qr.ctx)
+	decoders := columnDecoders(qr.ctx)
+	if rawBytes(qr.ctx) {
+		qr.bytes = new([]byte)
+	}
+	cfg := &converterConfig{
+		loc:     qr.stmt.conn.timestampLocation,
+		sized:   qr.stmt.conn.sizedIntegers,
+		numbers: qr.stmt.conn.numberDecoding,
+
+		typedMapKeys: typedMapKeys(qr.ctx),
+	}
+	if loc := resultLocation(qr.ctx); loc != nil {
+		cfg.loc, cfg.target = loc, loc
+	}
 	for i, col := range resp.Columns {
-		vc, err := newComplexConverter(col.TypeSignature)
-		if err != nil {
-			return fmt.Errorf("presto: creating complex converter for %s: %w", col.Name, err)
+		var vc driver.ValueConverter = rawConverter{}
+		if d, ok := decoders[col.Name]; ok {
+			vc = d
+		} else if streams && !raw && col.TypeSignature.RawType == "varbinary" {
+			vc = binaryStreamConverter{}
+		} else if qr.bytes != nil && !raw && isBytesType(col.TypeSignature.RawType) {
+			vc = bytesConverter{buf: qr.bytes, binary: col.TypeSignature.RawType == "varbinary"}
+		} else if !raw {
+			var err error
+			vc, err = newComplexConverter(col.TypeSignature, cfg)
+			if err != nil {
+				return fmt.Errorf("presto: creating complex converter for %s: %w", col.Name, err)
+			}
+		}
+		ts := col.rawTypeSignature
+		if ts == nil {
+			ts, _ = json.Marshal(col.TypeSignature)
 		}
 		qr.columns[i] = rowsColumn{
-			name:   col.Name,
-			dbType: col.Type,
-			vc:     vc,
+			name:          col.Name,
+			dbType:        col.Type,
+			typeSignature: ts,
+			vc:            vc,
+			scanType:      scanTypeOf(vc),
 		}
 	}
 	return nil
 }
 
 type typeConverter struct {
-	typeName   string
-	parsedType []string // e.g. array, array, varchar, for [][]string
+	typeName string
+	baseType string         // lower case outermost type, e.g. array for array(varchar)
+	loc      *time.Location // location of temporal values without a time zone
+	target   *time.Location // location temporal values are converted to, if set
+	sized    bool           // deliver integers with the width of their type
+	numbers  string         // mode numbers are delivered in, if not their default type
 }
 
-func newTypeConverter(typeName string) driver.ValueConverter {
+// newTypeConverter returns a converter for values of the given type. It is
+// built once per column of a query, so the type is parsed here rather than
+// for every value.
+func newTypeConverter(typeName string) *typeConverter {
 	return &typeConverter{
-		typeName:   typeName,
-		parsedType: parseType(typeName),
+		typeName: typeName,
+		baseType: strings.ToLower(parseType(typeName)[0]),
+		loc:      time.Local,
 	}
 }
 
@@ -928,7 +3573,16 @@ func parseType(name string) []string {
 
 // ConvertValue implements the driver.ValueConverter interface.
 func (c *typeConverter) ConvertValue(v interface{}) (driver.Value, error) {
-	switch strings.ToLower(c.parsedType[0]) {
+	if isSketchType(c.baseType) {
+		if v == nil {
+			return nil, nil
+		}
+		return scanSketch(v)
+	}
+	if c.numbers != "" {
+		return convertNumber(v, c.numbers)
+	}
+	switch c.baseType {
 	case "boolean":
 		vv, err := scanNullBool(v)
 		if !vv.Valid {
@@ -946,6 +3600,9 @@ func (c *typeConverter) ConvertValue(v interface{}) (driver.Value, error) {
 		if !vv.Valid {
 			return nil, err
 		}
+		if c.sized {
+			return sizedInt(c.baseType, vv.Int64)
+		}
 		return vv.Int64, err
 	case "real", "double":
 		vv, err := scanNullFloat64(v)
@@ -954,16 +3611,24 @@ func (c *typeConverter) ConvertValue(v interface{}) (driver.Value, error) {
 		}
 		return vv.Float64, err
 	case "date", "time", "time with time zone", "timestamp", "timestamp with time zone":
-		vv, err := scanNullTime(v)
+		vv, err := scanNullTime(v, c.loc)
 		if !vv.Valid {
 			return nil, err
 		}
+		if c.target != nil {
+			vv.Time = vv.Time.In(c.target)
+		}
 		return vv.Time, err
 	case "map":
 		if err := validateMap(v); err != nil {
 			return nil, err
 		}
 		return v, nil
+	case "bingtile":
+		if v == nil {
+			return nil, nil
+		}
+		return scanBingTile(v)
 	case "array":
 		if err := validateSlice(v); err != nil {
 			return nil, err
@@ -974,11 +3639,134 @@ func (c *typeConverter) ConvertValue(v interface{}) (driver.Value, error) {
 	}
 }
 
+// sizedInt returns an integer value as the Go type of the width of its
+// type.
+func sizedInt(baseType string, v int64) (driver.Value, error) {
+	switch baseType {
+	case "tinyint":
+		if v < math.MinInt8 || v > math.MaxInt8 {
+			return nil, fmt.Errorf("presto: %d overflows int8", v)
+		}
+		return int8(v), nil
+	case "smallint":
+		if v < math.MinInt16 || v > math.MaxInt16 {
+			return nil, fmt.Errorf("presto: %d overflows int16", v)
+		}
+		return int16(v), nil
+	case "integer":
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return nil, fmt.Errorf("presto: %d overflows int32", v)
+		}
+		return int32(v), nil
+	}
+	return v, nil
+}
+
+// numberClass returns the class of the numbers of the base type for the
+// number_decoding DSN parameter, empty for other types.
+func numberClass(baseType string) string {
+	switch baseType {
+	case "tinyint", "smallint", "integer", "bigint":
+		return "bigint"
+	case "real", "double":
+		return "double"
+	case "decimal":
+		return "decimal"
+	}
+	return ""
+}
+
+// convertNumber returns a number as sent by presto, a JSON number or a
+// string for decimals and non-finite doubles, as the Go type of the mode.
+func convertNumber(v interface{}, mode string) (driver.Value, error) {
+	var s string
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case json.Number:
+		s = string(x)
+	case string:
+		s = x
+	default:
+		return nil, fmt.Errorf("presto: cannot convert %v (%T) to a number", v, v)
+	}
+	switch mode {
+	case "float64":
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("presto: cannot convert %q to float64", s)
+		}
+		return f, nil
+	case "number":
+		return json.Number(s), nil
+	}
+	return s, nil
+}
+
+// scanTypes are the Go types values of the base types are delivered as.
+var scanTypes = map[string]reflect.Type{
+	"boolean":                  reflect.TypeOf(false),
+	"json":                     reflect.TypeOf(""),
+	"char":                     reflect.TypeOf(""),
+	"varchar":                  reflect.TypeOf(""),
+	"varbinary":                reflect.TypeOf(""),
+	"interval year to month":   reflect.TypeOf(""),
+	"interval day to second":   reflect.TypeOf(""),
+	"decimal":                  reflect.TypeOf(""),
+	"ipaddress":                reflect.TypeOf(""),
+	"tinyint":                  reflect.TypeOf(int8(0)),
+	"smallint":                 reflect.TypeOf(int16(0)),
+	"integer":                  reflect.TypeOf(int32(0)),
+	"bigint":                   reflect.TypeOf(int64(0)),
+	"real":                     reflect.TypeOf(float64(0)),
+	"double":                   reflect.TypeOf(float64(0)),
+	"date":                     reflect.TypeOf(time.Time{}),
+	"time":                     reflect.TypeOf(time.Time{}),
+	"time with time zone":      reflect.TypeOf(time.Time{}),
+	"timestamp":                reflect.TypeOf(time.Time{}),
+	"timestamp with time zone": reflect.TypeOf(time.Time{}),
+	"map":                      reflect.TypeOf(map[string]interface{}{}),
+	"array":                    reflect.TypeOf([]interface{}{}),
+	"bingtile":                 reflect.TypeOf(BingTile{}),
+	"hyperloglog":              reflect.TypeOf([]byte(nil)),
+	"p4hyperloglog":            reflect.TypeOf([]byte(nil)),
+	"khyperloglog":             reflect.TypeOf([]byte(nil)),
+	"setdigest":                reflect.TypeOf([]byte(nil)),
+	"qdigest":                  reflect.TypeOf([]byte(nil)),
+	"tdigest":                  reflect.TypeOf([]byte(nil)),
+}
+
+// scanTypeOf returns the Go type of the values delivered by the converter
+// of a column.
+func scanTypeOf(vc driver.ValueConverter) reflect.Type {
+	switch c := vc.(type) {
+	case *typeConverter:
+		if c.numbers != "" {
+			return numberModes[c.numbers]
+		}
+		if t, ok := scanTypes[c.baseType]; ok {
+			return t
+		}
+	case *mapConverter:
+		return c.scanType()
+	case rawConverter:
+		return reflect.TypeOf("")
+	case bytesConverter:
+		return reflect.TypeOf(sql.RawBytes{})
+	case binaryStreamConverter:
+		return reflect.TypeOf((*BinaryReader)(nil))
+	}
+	return reflect.TypeOf((*interface{})(nil)).Elem()
+}
+
 func validateMap(v interface{}) error {
 	if v == nil {
 		return nil
 	}
-	if _, ok := v.(map[string]interface{}); !ok {
+	if _, ok := v.(map[string]interface{}); ok {
+		return nil
+	}
+	if _, ok := stringKeys(v); !ok {
 		return fmt.Errorf("cannot convert %v (%T) to map", v, v)
 	}
 	return nil
@@ -1186,6 +3974,38 @@ func (s *NullSlice3String) Scan(value interface{}) error {
 	return nil
 }
 
+// NullSliceJSON represents a slice of JSON documents that may be null, for
+// array(json) columns. The documents are kept as sent by presto rather than
+// decoded, and null elements are nil.
+type NullSliceJSON struct {
+	SliceJSON []json.RawMessage
+	Valid     bool
+}
+
+// Scan implements the sql.Scanner interface.
+func (s *NullSliceJSON) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	vs, ok := value.([]interface{})
+	if !ok {
+		return fmt.Errorf("presto: cannot convert %v (%T) to []json.RawMessage", value, value)
+	}
+	slice := make([]json.RawMessage, len(vs))
+	for i := range vs {
+		switch v := vs[i].(type) {
+		case nil:
+		case string:
+			slice[i] = json.RawMessage(v)
+		default:
+			return fmt.Errorf("presto: cannot convert %v (%T) to json.RawMessage", v, v)
+		}
+	}
+	s.SliceJSON = slice
+	s.Valid = true
+	return nil
+}
+
 func scanNullInt64(v interface{}) (sql.NullInt64, error) {
 	if v == nil {
 		return sql.NullInt64{}, nil
@@ -1287,6 +4107,59 @@ func (s *NullSlice3Int64) Scan(value interface{}) error {
 	return nil
 }
 
+// NullInt8 represents a tinyint value that may be null.
+type NullInt8 struct {
+	Int8  int8
+	Valid bool
+}
+
+// Scan implements the sql.Scanner interface.
+func (n *NullInt8) Scan(value interface{}) error {
+	v, err := scanNarrowInt(value, math.MinInt8, math.MaxInt8, "int8")
+	*n = NullInt8{Int8: int8(v.Int64), Valid: v.Valid}
+	return err
+}
+
+// NullInt16 represents a smallint value that may be null.
+type NullInt16 struct {
+	Int16 int16
+	Valid bool
+}
+
+// Scan implements the sql.Scanner interface.
+func (n *NullInt16) Scan(value interface{}) error {
+	v, err := scanNarrowInt(value, math.MinInt16, math.MaxInt16, "int16")
+	*n = NullInt16{Int16: int16(v.Int64), Valid: v.Valid}
+	return err
+}
+
+// scanNarrowInt scans an integer, as converted by the driver or as found in
+// arrays, checking that it is within [min, max].
+func scanNarrowInt(value interface{}, min, max int64, typeName string) (sql.NullInt64, error) {
+	var v sql.NullInt64
+	switch x := value.(type) {
+	case nil:
+		return v, nil
+	case int64:
+		v = sql.NullInt64{Int64: x, Valid: true}
+	case int8:
+		v = sql.NullInt64{Int64: int64(x), Valid: true}
+	case int16:
+		v = sql.NullInt64{Int64: int64(x), Valid: true}
+	case int32:
+		v = sql.NullInt64{Int64: int64(x), Valid: true}
+	default:
+		var err error
+		if v, err = scanNullInt64(value); err != nil {
+			return sql.NullInt64{}, fmt.Errorf("presto: %v", err)
+		}
+	}
+	if v.Int64 < min || v.Int64 > max {
+		return sql.NullInt64{}, fmt.Errorf("presto: %d overflows %s", v.Int64, typeName)
+	}
+	return v, nil
+}
+
 func scanNullFloat64(v interface{}) (sql.NullFloat64, error) {
 	if v == nil {
 		return sql.NullFloat64{}, nil
@@ -1311,6 +4184,34 @@ func scanNullFloat64(v interface{}) (sql.NullFloat64, error) {
 	}
 }
 
+// NullFloat32 represents a real value that may be null.
+type NullFloat32 struct {
+	Float32 float32
+	Valid   bool
+}
+
+// Scan implements the sql.Scanner interface.
+func (n *NullFloat32) Scan(value interface{}) error {
+	*n = NullFloat32{}
+	var v sql.NullFloat64
+	switch x := value.(type) {
+	case nil:
+		return nil
+	case float64:
+		v = sql.NullFloat64{Float64: x, Valid: true}
+	default:
+		var err error
+		if v, err = scanNullFloat64(value); err != nil {
+			return fmt.Errorf("presto: %v", err)
+		}
+	}
+	if !math.IsInf(v.Float64, 0) && math.Abs(v.Float64) > math.MaxFloat32 {
+		return fmt.Errorf("presto: %v overflows float32", v.Float64)
+	}
+	*n = NullFloat32{Float32: float32(v.Float64), Valid: true}
+	return nil
+}
+
 // NullSliceFloat64 represents a slice of float64 that may be null.
 type NullSliceFloat64 struct {
 	SliceFloat64 []sql.NullFloat64
@@ -1399,11 +4300,19 @@ var timeLayouts = []string{
 	"2006-01-02",
 	"15:04:05.000",
 	"2006-01-02 15:04:05.000",
+	// Values with another precision, which time.Parse accepts as a
+	// fractional second following the seconds field.
+	"15:04:05",
+	"2006-01-02 15:04:05",
 }
 
-func scanNullTime(v interface{}) (NullTime, error) {
-	if v == nil {
+func scanNullTime(v interface{}, loc *time.Location) (NullTime, error) {
+	switch vv := v.(type) {
+	case nil:
 		return NullTime{}, nil
+	case time.Time:
+		// Elements of temporal arrays are already converted.
+		return NullTime{Valid: true, Time: vv}, nil
 	}
 	vv, ok := v.(string)
 	if !ok {
@@ -1413,14 +4322,14 @@ func scanNullTime(v interface{}) (NullTime, error) {
 	if len(vparts) > 1 && !unicode.IsDigit(rune(vparts[len(vparts)-1][0])) {
 		return parseNullTimeWithLocation(vv)
 	}
-	return parseNullTime(vv)
+	return parseNullTime(vv, loc)
 }
 
-func parseNullTime(v string) (NullTime, error) {
+func parseNullTime(v string, loc *time.Location) (NullTime, error) {
 	var t time.Time
 	var err error
 	for _, layout := range timeLayouts {
-		t, err = time.ParseInLocation(layout, v, time.Local)
+		t, err = time.ParseInLocation(layout, v, loc)
 		if err == nil {
 			return NullTime{Valid: true, Time: t}, nil
 		}
@@ -1434,9 +4343,9 @@ func parseNullTimeWithLocation(v string) (NullTime, error) {
 		return NullTime{}, fmt.Errorf("cannot convert %v (%T) to time+zone", v, v)
 	}
 	stamp, location := v[:idx], v[idx+1:]
-	loc, err := time.LoadLocation(location)
+	loc, err := loadZone(location)
 	if err != nil {
-		return NullTime{}, fmt.Errorf("cannot load timezone %q: %v", location, err)
+		return NullTime{}, err
 	}
 	var t time.Time
 	for _, layout := range timeLayouts {
@@ -1448,6 +4357,74 @@ func parseNullTimeWithLocation(v string) (NullTime, error) {
 	return NullTime{}, err
 }
 
+// zoneCache maps the tz database names found in temporal values to their
+// locations. Abbreviations and numeric offsets are not cached, as their
+// fixed zones are cheap to build and their spellings are unbounded.
+var zoneCache sync.Map
+
+// zoneAbbreviations maps common time zone abbreviations that the tz
+// database does not define as locations to their UTC offsets, those of the
+// North American and European zones.
+var zoneAbbreviations = map[string]int{
+	"PST":  -8 * 3600,
+	"PDT":  -7 * 3600,
+	"MDT":  -6 * 3600,
+	"CDT":  -5 * 3600,
+	"EDT":  -4 * 3600,
+	"AKST": -9 * 3600,
+	"AKDT": -8 * 3600,
+	"CEST": 2 * 3600,
+	"EEST": 3 * 3600,
+}
+
+// ambiguousZoneAbbreviations are the common time zone abbreviations with
+// several meanings, which are rejected rather than guessed: CST is Central
+// Standard Time, China Standard Time and Cuba Standard Time, BST is British
+// Summer Time and Bangladesh Standard Time, and IST is India, Irish and
+// Israel Standard Time.
+var ambiguousZoneAbbreviations = map[string]bool{
+	"CST": true,
+	"BST": true,
+	"IST": true,
+}
+
+// languageTag matches the syntax of BCP 47 language tags, e.g. en-US.
+var languageTag = regexp.MustCompile(`^[A-Za-z]{2,8}(-[A-Za-z0-9]{1,8})*$`)
+
+var zoneOffset = regexp.MustCompile(`^(?:UTC|GMT)?([+-])(\d{1,2})(?::?(\d{2}))?$`)
+
+// loadZone returns the location of a time zone rendered by presto, which is
+// either a region name such as America/New_York, an abbreviation, or a
+// numeric offset such as +05:30 of at most 18 hours.
+func loadZone(name string) (*time.Location, error) {
+	if loc, ok := zoneCache.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	if m := zoneOffset.FindStringSubmatch(name); m != nil {
+		hours, _ := strconv.Atoi(m[2])
+		minutes, _ := strconv.Atoi(m[3])
+		if hours > 18 || minutes >= 60 || hours == 18 && minutes > 0 {
+			return nil, fmt.Errorf("cannot load timezone %q: offset out of range", name)
+		}
+		offset := hours*3600 + minutes*60
+		if m[1] == "-" {
+			offset = -offset
+		}
+		return time.FixedZone(name, offset), nil
+	}
+	if abbr := strings.ToUpper(name); ambiguousZoneAbbreviations[abbr] {
+		return nil, fmt.Errorf("cannot load timezone %q: ambiguous abbreviation", name)
+	} else if offset, ok := zoneAbbreviations[abbr]; ok {
+		return time.FixedZone(name, offset), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load timezone %q: %v", name, err)
+	}
+	zoneCache.Store(name, loc)
+	return loc, nil
+}
+
 // NullTime represents a time.Time value that can be null.
 // The NullTime supports presto's Date, Time and Timestamp data types,
 // with or without time zone.
@@ -1484,7 +4461,7 @@ func (s *NullSliceTime) Scan(value interface{}) error {
 	}
 	slice := make([]NullTime, len(vs))
 	for i := range vs {
-		v, err := scanNullTime(vs[i])
+		v, err := scanNullTime(vs[i], time.Local)
 		if err != nil {
 			return err
 		}
@@ -1563,9 +4540,38 @@ func (m *NullMap) Scan(v interface{}) error {
 		return nil
 	}
 	m.Map, m.Valid = v.(map[string]interface{})
+	if !m.Valid {
+		m.Map, m.Valid = stringKeys(v)
+	}
 	return nil
 }
 
+// stringKeys returns a map delivered with numeric or boolean keys with its
+// keys formatted back to strings.
+func stringKeys(v interface{}) (map[string]interface{}, bool) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Map {
+		return nil, false
+	}
+	m := make(map[string]interface{}, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		var k string
+		switch x := iter.Key().Interface().(type) {
+		case int64:
+			k = strconv.FormatInt(x, 10)
+		case float64:
+			k = strconv.FormatFloat(x, 'g', -1, 64)
+		case bool:
+			k = strconv.FormatBool(x)
+		default:
+			return nil, false
+		}
+		m[k] = iter.Value().Interface()
+	}
+	return m, true
+}
+
 // NullSliceMap represents a slice of NullMap that may be null.
 type NullSliceMap struct {
 	SliceMap []NullMap
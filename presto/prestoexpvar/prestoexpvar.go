@@ -0,0 +1,53 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prestoexpvar publishes the statistics of the presto driver as
+// expvar variables, served by the standard /debug/vars endpoint.
+//
+// It is a separate package because importing expvar registers its handler
+// on http.DefaultServeMux.
+package prestoexpvar
+
+import (
+	"expvar"
+
+	"github.com/prestodb/presto-go-client/presto"
+)
+
+// Publish publishes the driver statistics as expvar variables named with the
+// given prefix, such as "presto.queries" for the prefix "presto.". Like
+// expvar.Publish, it panics if a variable with the same name already exists,
+// so call it once per prefix. The events of the event log enabled by
+// presto.SetEventLogSize are published as "recent_queries".
+func Publish(prefix string) {
+	for name, stat := range map[string]func(presto.DriverStats) int64{
+		"requests":      func(s presto.DriverStats) int64 { return s.Requests },
+		"retries":       func(s presto.DriverStats) int64 { return s.Retries },
+		"rate_limited":  func(s presto.DriverStats) int64 { return s.RateLimited },
+		"reexecutions":  func(s presto.DriverStats) int64 { return s.Reexecutions },
+		"queries":       func(s presto.DriverStats) int64 { return s.Queries },
+		"failures":      func(s presto.DriverStats) int64 { return s.Failures },
+		"bytes_fetched": func(s presto.DriverStats) int64 { return s.BytesFetched },
+		"open_cursors":  func(s presto.DriverStats) int64 { return s.OpenCursors },
+		"failovers":     func(s presto.DriverStats) int64 { return s.Failovers },
+	} {
+		stat := stat
+		expvar.Publish(prefix+name, expvar.Func(func() interface{} {
+			return stat(presto.Stats())
+		}))
+	}
+	expvar.Publish(prefix+"recent_queries", expvar.Func(func() interface{} {
+		return presto.RecentQueries()
+	}))
+}
@@ -0,0 +1,61 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prestoexpvar
+
+import (
+	"database/sql"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prestodb/presto-go-client/presto"
+)
+
+func TestPublish(t *testing.T) {
+	Publish("test.presto.")
+	presto.SetEventLogSize(1)
+	defer presto.SetEventLogSize(0)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = db.Query("SELECT 1"); err == nil {
+		t.Fatal("unexpected query succeeded")
+	}
+	for name, want := range map[string]string{
+		"test.presto.queries":      "1",
+		"test.presto.failures":     "1",
+		"test.presto.open_cursors": "0",
+	} {
+		v := expvar.Get(name)
+		if v == nil || v.String() != want {
+			t.Errorf("unexpected value of %s: %v", name, v)
+		}
+	}
+	var events []presto.QueryEvent
+	if err := json.Unmarshal([]byte(expvar.Get("test.presto.recent_queries").String()), &events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Status != "failed" {
+		t.Errorf("unexpected recent queries: %+v", events)
+	}
+}
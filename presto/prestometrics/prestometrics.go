@@ -0,0 +1,123 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prestometrics exports the statistics of the presto driver as
+// prometheus metrics.
+//
+// Register a collector once per process:
+//
+//	prometheus.MustRegister(prestometrics.NewCollector())
+package prestometrics
+
+import (
+	"sync"
+
+	"github.com/prestodb/presto-go-client/presto"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "presto_client"
+
+var (
+	// duration is the histogram of the query durations, shared by all
+	// collectors so that the query hook observing it is added only once.
+	duration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "query_duration_seconds",
+		Help:      "Time from query submission until the results were closed.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+	})
+	durationHook sync.Once
+)
+
+// Collector is a prometheus.Collector of the driver statistics, shared by
+// all connections of the process.
+type Collector struct {
+	queries      *prometheus.Desc
+	failures     *prometheus.Desc
+	requests     *prometheus.Desc
+	retries      *prometheus.Desc
+	rateLimited  *prometheus.Desc
+	reexecutions *prometheus.Desc
+	bytes        *prometheus.Desc
+	openCursors  *prometheus.Desc
+	failovers    *prometheus.Desc
+	duration     prometheus.Histogram
+}
+
+var _ prometheus.Collector = &Collector{}
+
+// NewCollector returns a new collector of the driver statistics. The
+// duration of queries is observed from the first call on, once for all
+// collectors.
+func NewCollector() *Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, nil, nil)
+	}
+	c := &Collector{
+		queries:      desc("queries_total", "Queries submitted."),
+		failures:     desc("query_failures_total", "Queries that failed on submission or while fetching results."),
+		requests:     desc("requests_total", "HTTP requests sent to presto."),
+		retries:      desc("request_retries_total", "Requests retried after 503 Service Unavailable."),
+		rateLimited:  desc("rate_limited_requests_total", "Requests rejected with 429 Too Many Requests."),
+		reexecutions: desc("query_reexecutions_total", "Idempotent queries re-executed after failing mid-stream."),
+		bytes:        desc("fetched_bytes_total", "Bytes of response bodies read from presto."),
+		openCursors:  desc("open_cursors", "Query results not closed yet."),
+		failovers:    desc("failovers_total", "Submissions retried on another coordinator after a gateway error."),
+		duration:     duration,
+	}
+	durationHook.Do(func() {
+		presto.AddQueryHook(func(e presto.QueryEnd) {
+			duration.Observe(e.Duration.Seconds())
+		})
+	})
+	return c
+}
+
+// Describe implements the prometheus.Collector interface.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.queries
+	ch <- c.failures
+	ch <- c.requests
+	ch <- c.retries
+	ch <- c.rateLimited
+	ch <- c.reexecutions
+	ch <- c.bytes
+	ch <- c.openCursors
+	ch <- c.failovers
+	c.duration.Describe(ch)
+}
+
+// Collect implements the prometheus.Collector interface.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	s := presto.Stats()
+	for _, m := range []struct {
+		desc  *prometheus.Desc
+		typ   prometheus.ValueType
+		value int64
+	}{
+		{c.queries, prometheus.CounterValue, s.Queries},
+		{c.failures, prometheus.CounterValue, s.Failures},
+		{c.requests, prometheus.CounterValue, s.Requests},
+		{c.retries, prometheus.CounterValue, s.Retries},
+		{c.rateLimited, prometheus.CounterValue, s.RateLimited},
+		{c.reexecutions, prometheus.CounterValue, s.Reexecutions},
+		{c.bytes, prometheus.CounterValue, s.BytesFetched},
+		{c.openCursors, prometheus.GaugeValue, s.OpenCursors},
+		{c.failovers, prometheus.CounterValue, s.Failovers},
+	} {
+		ch <- prometheus.MustNewConstMetric(m.desc, m.typ, float64(m.value))
+	}
+	c.duration.Collect(ch)
+}
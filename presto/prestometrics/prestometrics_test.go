@@ -0,0 +1,74 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prestometrics
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	_ "github.com/prestodb/presto-go-client/presto"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollector(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(NewCollector()); err != nil {
+		t.Fatal(err)
+	}
+	// Collectors share the query hook, which observes every query once.
+	NewCollector()
+	db, err := sql.Open("presto", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = db.Query("SELECT 1"); err == nil {
+		t.Fatal("unexpected query succeeded")
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, mf := range mfs {
+		m := mf.GetMetric()[0]
+		switch {
+		case m.GetCounter() != nil:
+			values[mf.GetName()] = m.GetCounter().GetValue()
+		case m.GetGauge() != nil:
+			values[mf.GetName()] = m.GetGauge().GetValue()
+		case m.GetHistogram() != nil:
+			values[mf.GetName()] = float64(m.GetHistogram().GetSampleCount())
+		}
+	}
+	for name, want := range map[string]float64{
+		"presto_client_queries_total":               1,
+		"presto_client_query_failures_total":        1,
+		"presto_client_requests_total":              1,
+		"presto_client_open_cursors":                0,
+		"presto_client_query_duration_seconds":      1,
+		"presto_client_rate_limited_requests_total": 0,
+	} {
+		if v, ok := values[name]; !ok || v != want {
+			t.Errorf("unexpected value of %s: %v", name, v)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// minQueueFullBackoff and maxQueueFullBackoff bound the time queries
+// rejected by a full server queue wait before being resubmitted.
+var (
+	minQueueFullBackoff = time.Second
+	maxQueueFullBackoff = 30 * time.Second
+)
+
+// maxResourceRetryBackoff bounds the time queries that failed for lack of
+// resources wait before being resubmitted.
+var maxResourceRetryBackoff = 5 * time.Minute
+
+// clientQueue holds the queries rejected because the server queues are
+// full, until they are resubmitted. The queue is shared by the connections
+// of a sql.DB.
+type clientQueue struct {
+	// slots bounds the number of queries held at once, nil if unbounded.
+	slots chan struct{}
+}
+
+func newClientQueue(size int) *clientQueue {
+	q := &clientQueue{}
+	if size > 0 {
+		q.slots = make(chan struct{}, size)
+	}
+	return q
+}
+
+// hold waits before the given resubmission of a query rejected with cause,
+// backing off exponentially. It returns cause right away if the queue is
+// full, and the context error if it is done while waiting.
+func (q *clientQueue) hold(ctx context.Context, retry int, cause error) error {
+	if q.slots != nil {
+		select {
+		case q.slots <- struct{}{}:
+			defer func() { <-q.slots }()
+		default:
+			return cause
+		}
+	}
+	return sleepBackoff(ctx, minQueueFullBackoff, maxQueueFullBackoff, retry)
+}
+
+// backoff returns the wait before the given retry, min doubled for every
+// previous one and at most max.
+func backoff(min, max time.Duration, retry int) time.Duration {
+	if retry < 16 && min<<uint(retry) < max {
+		return min << uint(retry)
+	}
+	return max
+}
+
+// sleepBackoff waits before the given retry, min doubled for every previous
+// one and at most max. It returns the context error if it is done while
+// waiting.
+func sleepBackoff(ctx context.Context, min, max time.Duration, retry int) error {
+	timer := time.NewTimer(backoff(min, max, retry))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// isQueueFull reports whether a query was rejected because the server
+// queues are full or the server is overloaded.
+func isQueueFull(err error) bool {
+	var qf *ErrQueryFailed
+	if !errors.As(err, &qf) {
+		return false
+	}
+	se, ok := qf.Reason.(*stmtError)
+	return ok && (se.ErrorName == "QUERY_QUEUE_FULL" || se.ErrorName == "TOO_MANY_REQUESTS_FAILED")
+}
+
+// isInsufficientResources reports whether a query failed because the
+// cluster ran out of resources, e.g. memory, other than its queues being
+// full.
+func isInsufficientResources(err error) bool {
+	var qf *ErrQueryFailed
+	return errors.As(err, &qf) && qf.ErrorType == ErrorTypeInsufficientResources && !isQueueFull(err)
+}
@@ -0,0 +1,226 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestQueueFullRetries(t *testing.T) {
+	defer func(d time.Duration) { minQueueFullBackoff = d }(minQueueFullBackoff)
+	minQueueFullBackoff = time.Millisecond
+
+	submissions := 0
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			submissions++
+			json.NewEncoder(w).Encode(&stmtResponse{
+				ID:      "test_query",
+				NextURI: ts.URL + "/v1/statement/test_query/0",
+			})
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			resp := queryResponse{ID: "test_query"}
+			if submissions < 3 {
+				resp.Error = stmtError{ErrorName: "QUERY_QUEUE_FULL", ErrorType: "INSUFFICIENT_RESOURCES"}
+			}
+			json.NewEncoder(w).Encode(&resp)
+		}
+	}))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		retries     string
+		submissions int
+		fail        bool
+	}{
+		{"0", 1, true},
+		{"1", 2, true},
+		{"2", 3, false},
+	} {
+		t.Run(tc.retries, func(t *testing.T) {
+			submissions = 0
+			db, err := sql.Open("presto", ts.URL+"?queue_full_retries="+tc.retries)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			rows, err := db.Query("SELECT 1")
+			if err == nil {
+				rows.Close()
+			}
+			if (err != nil) != tc.fail || submissions != tc.submissions {
+				t.Fatalf("unexpected result after %d submissions: %v", submissions, err)
+			}
+			if tc.fail && !isQueueFull(err) {
+				t.Fatal("unexpected error:", err)
+			}
+		})
+	}
+}
+
+func TestClientQueueBound(t *testing.T) {
+	q := newClientQueue(1)
+	q.slots <- struct{}{}
+	cause := &ErrQueryFailed{Reason: &stmtError{ErrorName: "QUERY_QUEUE_FULL"}}
+	if err := q.hold(context.Background(), 0, cause); err != cause {
+		t.Fatal("query held in a full client queue:", err)
+	}
+}
+
+func TestResourceRetries(t *testing.T) {
+	columns := []queryColumn{{Name: "x", Type: "bigint", TypeSignature: typeSignature{RawType: "bigint"}}}
+	memoryLimit := stmtError{ErrorName: "EXCEEDED_GLOBAL_MEMORY_LIMIT", ErrorType: "INSUFFICIENT_RESOURCES"}
+	submissions := 0
+	// failPage is the page failing in the first two submissions, and
+	// firstData whether the first page has rows.
+	failPage, firstData := 0, false
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			submissions++
+			json.NewEncoder(w).Encode(&stmtResponse{
+				ID:      "test_query",
+				NextURI: ts.URL + "/v1/statement/test_query/0",
+			})
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			resp := queryResponse{ID: "test_query", Columns: columns}
+			page := 0
+			if r.URL.Path == "/v1/statement/test_query/1" {
+				page = 1
+			}
+			switch {
+			case page == failPage && submissions < 3:
+				resp.Error = memoryLimit
+			case page == 0:
+				resp.NextURI = ts.URL + "/v1/statement/test_query/1"
+				if firstData {
+					resp.Data = []queryData{{json.Number("1")}}
+				}
+			default:
+				resp.Data = []queryData{{json.Number("2")}}
+			}
+			json.NewEncoder(w).Encode(&resp)
+		}
+	}))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		name        string
+		dsn         string
+		failPage    int
+		firstData   bool
+		submissions int
+		fail        bool
+	}{
+		{"disabled", "", 0, false, 1, true},
+		{"submission", "?resource_retries=2&resource_retry_backoff=1ms", 0, false, 3, false},
+		{"exhausted", "?resource_retries=1&resource_retry_backoff=1ms", 0, false, 2, true},
+		{"fetch", "?resource_retries=2&resource_retry_backoff=1ms", 1, false, 3, false},
+		{"delivered", "?resource_retries=2&resource_retry_backoff=1ms", 1, true, 1, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			submissions, failPage, firstData = 0, tc.failPage, tc.firstData
+			db, err := sql.Open("presto", ts.URL+tc.dsn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			rows, err := db.Query("SELECT x")
+			if err == nil {
+				for rows.Next() {
+				}
+				err = rows.Err()
+				rows.Close()
+			}
+			var eof *EOF
+			if errors.As(err, &eof) {
+				err = nil
+			}
+			if (err != nil) != tc.fail || submissions != tc.submissions {
+				t.Fatalf("unexpected result after %d submissions: %v", submissions, err)
+			}
+			if tc.fail && !isInsufficientResources(err) {
+				t.Fatal("unexpected error:", err)
+			}
+		})
+	}
+}
+
+func TestRetryBudgetResubmissions(t *testing.T) {
+	defer func(d time.Duration) { minQueueFullBackoff = d }(minQueueFullBackoff)
+	minQueueFullBackoff = time.Millisecond
+
+	submissions := 0
+	var failure stmtError
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			submissions++
+			json.NewEncoder(w).Encode(&stmtResponse{
+				ID:      "test_query",
+				NextURI: ts.URL + "/v1/statement/test_query/0",
+			})
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			json.NewEncoder(w).Encode(&queryResponse{ID: "test_query", Error: failure})
+		}
+	}))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		name    string
+		dsn     string
+		failure stmtError
+	}{
+		{"queue full", "?queue_full_retries=5&retry_budget=2", stmtError{ErrorName: "QUERY_QUEUE_FULL", ErrorType: "INSUFFICIENT_RESOURCES"}},
+		{"resources", "?resource_retries=5&resource_retry_backoff=1ms&retry_budget=2", stmtError{ErrorName: "EXCEEDED_GLOBAL_MEMORY_LIMIT", ErrorType: "INSUFFICIENT_RESOURCES"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			submissions, failure = 0, tc.failure
+			db, err := sql.Open("presto", ts.URL+tc.dsn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			rows, err := db.Query("SELECT 1")
+			if err == nil {
+				for rows.Next() {
+				}
+				err = rows.Err()
+				rows.Close()
+			}
+			var be *ErrRetryBudgetExceeded
+			if !errors.As(err, &be) || be.Retries != 2 || submissions != 3 {
+				t.Fatalf("unexpected result after %d submissions: %v", submissions, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	prestoRoleHeader    = "X-Presto-Role"
+	prestoSetRoleHeader = "X-Presto-Set-Role"
+)
+
+var catalogName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// parseRoles returns the roles of a comma separated list of catalog=role
+// pairs, by catalog, as selected roles: ALL, NONE or ROLE{role}.
+func parseRoles(v string) (map[string]string, error) {
+	roles := make(map[string]string)
+	for _, kv := range strings.Split(v, ",") {
+		catalog, role, _ := strings.Cut(kv, "=")
+		catalog, role = strings.TrimSpace(catalog), strings.TrimSpace(role)
+		if !catalogName.MatchString(catalog) || role == "" {
+			return nil, &ErrInvalidDSN{Param: "roles", Value: v}
+		}
+		roles[catalog] = selectedRole(role)
+	}
+	return roles, nil
+}
+
+// selectedRole returns a role as selected by SET ROLE, where ALL and NONE
+// are not role names.
+func selectedRole(role string) string {
+	switch upper := strings.ToUpper(role); upper {
+	case "ALL", "NONE":
+		return upper
+	}
+	return "ROLE{" + role + "}"
+}
+
+// updateRoles applies the roles set by a SET ROLE statement, as sent in
+// the response headers, to the subsequent queries of the connection.
+func (c *Conn) updateRoles(h http.Header) {
+	set := h.Values(prestoSetRoleHeader)
+	if len(set) == 0 {
+		return
+	}
+	if c.roles == nil {
+		c.roles = make(map[string]string)
+	}
+	for _, v := range set {
+		catalog, role, ok := strings.Cut(v, "=")
+		if !ok {
+			continue
+		}
+		if role, err := url.QueryUnescape(role); err == nil {
+			c.roles[catalog] = role
+		}
+	}
+	c.setRoleHeaders()
+}
+
+// setRoleHeaders sets the role headers of the connection, one per catalog.
+func (c *Conn) setRoleHeaders() {
+	catalogs := make([]string, 0, len(c.roles))
+	for catalog := range c.roles {
+		catalogs = append(catalogs, catalog)
+	}
+	sort.Strings(catalogs)
+	c.httpHeaders.Del(prestoRoleHeader)
+	for _, catalog := range catalogs {
+		c.httpHeaders.Add(prestoRoleHeader, catalog+"="+url.QueryEscape(c.roles[catalog]))
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+	var roles [][]string
+	ts := newPagedServer(queryResponse{})
+	ts.Config.Handler = func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				roles = append(roles, r.Header.Values(prestoRoleHeader))
+				b, _ := io.ReadAll(r.Body)
+				if strings.HasPrefix(string(b), "SET ROLE") {
+					w.Header().Add(prestoSetRoleHeader, "hive=ROLE%7Banalyst%7D")
+					w.Header().Add(prestoSetRoleHeader, "system=ALL")
+				}
+			}
+			h.ServeHTTP(w, r)
+		})
+	}(ts.Config.Handler)
+	defer ts.Close()
+
+	dsn, err := (&Config{PrestoURI: ts.URL, Roles: map[string]string{"hive": "admin", "raptor": "none"}}).FormatDSN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dsn, "roles=hive%3Dadmin%2Craptor%3Dnone") {
+		t.Fatal("unexpected dsn:", dsn)
+	}
+	db, err := sql.Open("presto", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	for _, query := range []string{"SELECT 1", "SET ROLE analyst IN hive", "SELECT 1"} {
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+
+	want := [][]string{
+		{"hive=ROLE%7Badmin%7D", "raptor=NONE"},
+		{"hive=ROLE%7Badmin%7D", "raptor=NONE"},
+		{"hive=ROLE%7Banalyst%7D", "raptor=NONE", "system=ALL"},
+	}
+	if !reflect.DeepEqual(roles, want) {
+		t.Fatalf("want role headers %q, got %q", want, roles)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// Queryer is implemented by *sql.DB, *sql.Conn and *sql.Tx.
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// SchemaOf returns the names and types of the columns of the result of
+// query without fetching any row, e.g. for report builders that discover
+// the shape of a result. The query is run with LIMIT 0, and cancelled as
+// soon as presto has sent its columns.
+func SchemaOf(ctx context.Context, q Queryer, query string, args ...interface{}) ([]*sql.ColumnType, error) {
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rows, err := q.QueryContext(ctx, "SELECT * FROM (\n"+query+"\n) LIMIT 0", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cts, err := rows.ColumnTypes()
+	if err == nil && len(cts) == 0 {
+		// The columns failed to arrive, and the rows hold the reason.
+		for rows.Next() {
+		}
+		err = rows.Err()
+	}
+	return cts, err
+}
+
+// Function is a function of presto, as listed by SHOW FUNCTIONS. Overloads
+// of a function are listed as separate functions with the same name.
+type Function struct {
+	Name          string
+	ReturnType    string
+	ArgumentTypes []string
+	// Kind is the kind of the function: scalar, aggregate or window.
+	Kind          string
+	Deterministic bool
+	Description   string
+}
+
+// Signature returns the signature of the function, e.g.
+// "substr(varchar, bigint): varchar".
+func (f Function) Signature() string {
+	return f.Name + "(" + strings.Join(f.ArgumentTypes, ", ") + "): " + f.ReturnType
+}
+
+// ListFunctions returns the functions of presto, e.g. for editors to
+// complete function names and show their signatures.
+func ListFunctions(ctx context.Context, q Queryer) ([]Function, error) {
+	rows, err := q.QueryContext(ctx, "SHOW FUNCTIONS")
+	if err != nil {
+		return nil, err
+	}
+	fs, err := Collect[struct {
+		Name          string         `presto:"Function"`
+		ReturnType    string         `presto:"Return Type"`
+		ArgumentTypes string         `presto:"Argument Types"`
+		Kind          string         `presto:"Function Type"`
+		Deterministic sql.NullBool   `presto:"Deterministic"`
+		Description   sql.NullString `presto:"Description"`
+	}](rows)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Function, len(fs))
+	for i, f := range fs {
+		res[i] = Function{
+			Name:          f.Name,
+			ReturnType:    f.ReturnType,
+			ArgumentTypes: splitArgumentTypes(f.ArgumentTypes),
+			Kind:          f.Kind,
+			Deterministic: f.Deterministic.Bool,
+			Description:   f.Description.String,
+		}
+	}
+	return res, nil
+}
+
+// splitArgumentTypes splits the argument types listed by SHOW FUNCTIONS at
+// the commas outside of the parentheses of parametric types, such as
+// decimal(p,s) or map(k,v).
+func splitArgumentTypes(s string) []string {
+	var types []string
+	depth, start := 0, 0
+	for i, r := range s {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				types = append(types, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if t := strings.TrimSpace(s[start:]); t != "" || len(types) > 0 {
+		types = append(types, t)
+	}
+	return types
+}
+
+// Catalog is a catalog of presto, with the session properties of its
+// connector.
+type Catalog struct {
+	Name        string
+	ConnectorID string
+	// Properties are the session properties of the catalog, named with
+	// the catalog as prefix, as set with SET SESSION, e.g.
+	// "hive.insert_existing_partitions_behavior".
+	Properties []SessionProperty
+}
+
+// SessionProperty is a session property, as listed by SHOW SESSION.
+type SessionProperty struct {
+	Name        string
+	Value       string
+	Default     string
+	Type        string
+	Description string
+}
+
+// ListCatalogs returns the catalogs of presto, in the order of their names,
+// with their session properties, e.g. for editors to complete catalog
+// names and SET SESSION statements.
+func ListCatalogs(ctx context.Context, q Queryer) ([]Catalog, error) {
+	rows, err := q.QueryContext(ctx, "SELECT catalog_name, connector_id FROM system.metadata.catalogs ORDER BY catalog_name")
+	if err != nil {
+		return nil, err
+	}
+	catalogs, err := Collect[struct {
+		Name        string         `presto:"catalog_name"`
+		ConnectorID sql.NullString `presto:"connector_id"`
+	}](rows)
+	if err != nil {
+		return nil, err
+	}
+	if rows, err = q.QueryContext(ctx, "SHOW SESSION"); err != nil {
+		return nil, err
+	}
+	props, err := Collect[struct {
+		Name        string         `presto:"Name"`
+		Value       sql.NullString `presto:"Value"`
+		Default     sql.NullString `presto:"Default"`
+		Type        sql.NullString `presto:"Type"`
+		Description sql.NullString `presto:"Description"`
+	}](rows)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Catalog, len(catalogs))
+	index := make(map[string]int, len(catalogs))
+	for i, c := range catalogs {
+		res[i] = Catalog{Name: c.Name, ConnectorID: c.ConnectorID.String}
+		index[c.Name] = i
+	}
+	for _, p := range props {
+		dot := strings.IndexByte(p.Name, '.')
+		if dot < 0 {
+			continue
+		}
+		i, ok := index[p.Name[:dot]]
+		if !ok {
+			continue
+		}
+		res[i].Properties = append(res[i].Properties, SessionProperty{
+			Name:        p.Name,
+			Value:       p.Value.String,
+			Default:     p.Default.String,
+			Type:        p.Type.String,
+			Description: p.Description.String,
+		})
+	}
+	return res, nil
+}
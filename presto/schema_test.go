@@ -0,0 +1,184 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaOf(t *testing.T) {
+	columns := []queryColumn{
+		{Name: "id", Type: "bigint", TypeSignature: typeSignature{RawType: "bigint"}},
+		{Name: "name", Type: "varchar", TypeSignature: typeSignature{RawType: "varchar"}},
+	}
+	ts := newPagedServer(
+		queryResponse{Stats: stmtStats{State: "QUEUED"}},
+		queryResponse{Columns: columns, Stats: stmtStats{State: "FINISHED"}},
+	)
+	var statements []string
+	ts.Config.Handler = func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				b, _ := io.ReadAll(r.Body)
+				statements = append(statements, string(b))
+			}
+			h.ServeHTTP(w, r)
+		})
+	}(ts.Config.Handler)
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	cts, err := SchemaOf(context.Background(), db, "SELECT id, name FROM users;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cts) != 2 || cts[0].Name() != "id" || cts[0].DatabaseTypeName() != "bigint" || cts[1].Name() != "name" {
+		t.Fatalf("unexpected columns: %v", cts)
+	}
+	if want := "SELECT * FROM (\nSELECT id, name FROM users\n) LIMIT 0"; len(statements) != 1 || statements[0] != want {
+		t.Fatalf("want statement %q, got %q", want, statements)
+	}
+
+	ts = newPagedServer(queryResponse{Stats: stmtStats{State: "FAILED"}, Error: stmtError{Message: "Table users does not exist"}})
+	defer ts.Close()
+	db, err = sql.Open("presto", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := SchemaOf(context.Background(), db, "SELECT * FROM users"); err == nil {
+		t.Fatal("want error for failed query")
+	}
+}
+
+// newStatementServer returns a server answering each statement with the
+// first page whose key prefixes it.
+func newStatementServer(t *testing.T, pages map[string]queryResponse) *httptest.Server {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			b, _ := io.ReadAll(r.Body)
+			for prefix := range pages {
+				if strings.HasPrefix(string(b), prefix) {
+					json.NewEncoder(w).Encode(&stmtResponse{
+						ID:      "test_query",
+						NextURI: ts.URL + "/v1/statement/test_query/" + url.PathEscape(prefix),
+					})
+					return
+				}
+			}
+			t.Errorf("unexpected statement: %s", b)
+			w.WriteHeader(http.StatusBadRequest)
+		case http.MethodGet:
+			page := pages[strings.TrimPrefix(r.URL.Path, "/v1/statement/test_query/")]
+			page.ID = "test_query"
+			page.Stats.State = "FINISHED"
+			json.NewEncoder(w).Encode(&page)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	return ts
+}
+
+func varcharColumns(names ...string) []queryColumn {
+	columns := make([]queryColumn, len(names))
+	for i, name := range names {
+		columns[i] = queryColumn{Name: name, Type: "varchar", TypeSignature: typeSignature{RawType: "varchar"}}
+	}
+	return columns
+}
+
+func TestListFunctions(t *testing.T) {
+	columns := append(varcharColumns("Function", "Return Type", "Argument Types", "Function Type"),
+		queryColumn{Name: "Deterministic", Type: "boolean", TypeSignature: typeSignature{RawType: "boolean"}},
+		queryColumn{Name: "Description", Type: "varchar", TypeSignature: typeSignature{RawType: "varchar"}},
+		queryColumn{Name: "Variable Arity", Type: "boolean", TypeSignature: typeSignature{RawType: "boolean"}},
+	)
+	ts := newStatementServer(t, map[string]queryResponse{
+		"SHOW FUNCTIONS": {Columns: columns, Data: []queryData{
+			{"abs", "decimal(p,s)", "decimal(p,s)", "scalar", true, "absolute value", false},
+			{"map_agg", "map(K,V)", "K, V", "aggregate", true, nil, false},
+			{"now", "timestamp with time zone", "", "scalar", false, "current timestamp", false},
+		}},
+	})
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	fs, err := ListFunctions(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Function{
+		{Name: "abs", ReturnType: "decimal(p,s)", ArgumentTypes: []string{"decimal(p,s)"}, Kind: "scalar", Deterministic: true, Description: "absolute value"},
+		{Name: "map_agg", ReturnType: "map(K,V)", ArgumentTypes: []string{"K", "V"}, Kind: "aggregate", Deterministic: true},
+		{Name: "now", ReturnType: "timestamp with time zone", Kind: "scalar", Description: "current timestamp"},
+	}
+	if !reflect.DeepEqual(fs, want) {
+		t.Fatalf("want functions %+v, got %+v", want, fs)
+	}
+	if got := fs[1].Signature(); got != "map_agg(K, V): map(K,V)" {
+		t.Fatal("unexpected signature:", got)
+	}
+}
+
+func TestListCatalogs(t *testing.T) {
+	ts := newStatementServer(t, map[string]queryResponse{
+		"SELECT catalog_name": {Columns: varcharColumns("catalog_name", "connector_id"), Data: []queryData{
+			{"hive", "hive"},
+			{"system", "system"},
+		}},
+		"SHOW SESSION": {Columns: varcharColumns("Name", "Value", "Default", "Type", "Description"), Data: []queryData{
+			{"query_max_run_time", "1h", "100d", "varchar", "Maximum run time of a query"},
+			{"hive.orc_bloom_filters_enabled", "true", "false", "boolean", "ORC: Enable bloom filters"},
+		}},
+	})
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	catalogs, err := ListCatalogs(context.Background(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Catalog{
+		{Name: "hive", ConnectorID: "hive", Properties: []SessionProperty{
+			{Name: "hive.orc_bloom_filters_enabled", Value: "true", Default: "false", Type: "boolean", Description: "ORC: Enable bloom filters"},
+		}},
+		{Name: "system", ConnectorID: "system"},
+	}
+	if !reflect.DeepEqual(catalogs, want) {
+		t.Fatalf("want catalogs %+v, got %+v", want, catalogs)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.23
+
+package presto
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"iter"
+)
+
+// Row is the current row of a query iterated with Query. It is only valid
+// within the loop iteration it is yielded to.
+type Row struct {
+	rows *sql.Rows
+}
+
+// Scan copies the columns of the row into the values pointed at by dest,
+// like sql.Rows.Scan.
+func (r *Row) Scan(dest ...interface{}) error {
+	return r.rows.Scan(dest...)
+}
+
+// Columns returns the column names.
+func (r *Row) Columns() ([]string, error) {
+	return r.rows.Columns()
+}
+
+// Query returns an iterator over the rows of query. The query is submitted
+// when the iteration starts, and its rows are closed and the query
+// cancelled on the server when the loop exits, including early exits.
+// A failure is yielded once, with a nil row, and ends the iteration.
+//
+//	for row, err := range presto.Query(ctx, db, "SELECT name FROM users") {
+//		if err != nil {
+//			return err
+//		}
+//		var name string
+//		if err := row.Scan(&name); err != nil {
+//			return err
+//		}
+//		...
+//	}
+func Query(ctx context.Context, q Queryer, query string, args ...interface{}) iter.Seq2[*Row, error] {
+	return func(yield func(*Row, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		rows, err := q.QueryContext(ctx, query, args...)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer rows.Close()
+		row := &Row{rows: rows}
+		for rows.Next() {
+			if !yield(row, nil) {
+				return
+			}
+		}
+		var eof *EOF
+		if err := rows.Err(); err != nil && !errors.As(err, &eof) {
+			yield(nil, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.23
+
+package presto
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestQuerySeq(t *testing.T) {
+	columns := []queryColumn{{Name: "x", Type: "bigint", TypeSignature: typeSignature{RawType: "bigint"}}}
+	ts := newPagedServer(
+		queryResponse{Columns: columns, Data: []queryData{{json.Number("1")}, {json.Number("2")}}},
+		queryResponse{Columns: columns, Data: []queryData{{json.Number("3")}}},
+	)
+	deletes := 0
+	ts.Config.Handler = func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodDelete {
+				deletes++
+			}
+			h.ServeHTTP(w, r)
+		})
+	}(ts.Config.Handler)
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var xs []int64
+	for row, err := range Query(context.Background(), db, "SELECT x") {
+		if err != nil {
+			t.Fatal(err)
+		}
+		var x int64
+		if err := row.Scan(&x); err != nil {
+			t.Fatal(err)
+		}
+		xs = append(xs, x)
+	}
+	if want := []int64{1, 2, 3}; !reflect.DeepEqual(xs, want) {
+		t.Fatalf("want rows %v, got %v", want, xs)
+	}
+
+	deletes = 0
+	for _, err := range Query(context.Background(), db, "SELECT x") {
+		if err != nil {
+			t.Fatal(err)
+		}
+		break
+	}
+	if deletes != 1 {
+		t.Fatalf("want query cancelled after early exit, got %d deletes", deletes)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	fdb, err := sql.Open("presto", failing.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fdb.Close()
+	n := 0
+	for row, err := range Query(context.Background(), fdb, "SELECT x") {
+		if row != nil || err == nil {
+			t.Fatalf("want only a submission error, got %v, %v", row, err)
+		}
+		n++
+	}
+	if n != 1 {
+		t.Fatalf("want 1 iteration, got %d", n)
+	}
+}
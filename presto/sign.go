@@ -0,0 +1,58 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// sign passes the request and the hash of its body to the signing function
+// of the connection, if any.
+func (c *Conn) sign(req *http.Request) error {
+	if c.signRequest == nil {
+		return nil
+	}
+	h := sha256.New()
+	switch {
+	case req.GetBody != nil:
+		body, err := req.GetBody()
+		if err != nil {
+			return fmt.Errorf("presto: signing request: %v", err)
+		}
+		_, err = io.Copy(h, body)
+		body.Close()
+		if err != nil {
+			return fmt.Errorf("presto: signing request: %v", err)
+		}
+	case req.Body != nil && req.Body != http.NoBody:
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return fmt.Errorf("presto: signing request: %v", err)
+		}
+		h.Write(b)
+		req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	}
+	if err := c.signRequest(req, hex.EncodeToString(h.Sum(nil))); err != nil {
+		return fmt.Errorf("presto: signing request: %w", err)
+	}
+	return nil
+}
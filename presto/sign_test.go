@@ -0,0 +1,75 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSignRequest(t *testing.T) {
+	ts := newPagedServer(queryResponse{})
+	var signatures []string
+	ts.Config.Handler = func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			sum := sha256.Sum256(b)
+			if want := r.Method + " " + r.URL.Path + " " + hex.EncodeToString(sum[:]); r.Header.Get("X-Signature") != want {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			signatures = append(signatures, r.Header.Get("X-Signature"))
+			r.Body = io.NopCloser(strings.NewReader(string(b)))
+			h.ServeHTTP(w, r)
+		})
+	}(ts.Config.Handler)
+	defer ts.Close()
+
+	var fail error
+	connector, err := NewConnector(&Config{
+		PrestoURI: ts.URL,
+		SignRequest: func(req *http.Request, bodyHash string) error {
+			if fail != nil {
+				return fail
+			}
+			req.Header.Set("X-Signature", req.Method+" "+req.URL.Path+" "+bodyHash)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	rows, err := db.Query("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	if len(signatures) != 2 || !strings.HasPrefix(signatures[0], "POST /v1/statement ") {
+		t.Fatalf("want signed submission and fetch, got %q", signatures)
+	}
+
+	fail = errors.New("no credentials")
+	if _, err := db.Query("SELECT 1"); err == nil || !strings.Contains(err.Error(), "no credentials") {
+		t.Fatal("want signing error, got", err)
+	}
+}
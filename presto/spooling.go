@@ -0,0 +1,176 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// The spooled result protocol lets servers return pages as a list of
+// segments, either inlined in the response or spooled to storage and
+// referenced by URI, instead of an array of rows. Clients opt in by sending
+// the encodings they support in the data encoding header.
+
+const (
+	// jsonDataEncoding is the only segment encoding supported by the driver.
+	jsonDataEncoding = "json"
+
+	// maxParallelSegments is the number of spooled segments of a page that
+	// are fetched concurrently.
+	maxParallelSegments = 4
+)
+
+type segmentedData struct {
+	Encoding string        `json:"encoding"`
+	Segments []dataSegment `json:"segments"`
+}
+
+type dataSegment struct {
+	Type     string                 `json:"type"` // inline or spooled
+	Data     []byte                 `json:"data"` // base64 encoded rows of inline segments
+	URI      string                 `json:"uri"`
+	AckURI   string                 `json:"ackUri"`
+	Headers  map[string][]string    `json:"headers"`
+	Metadata map[string]interface{} `json:"metadata"`
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, accepting both
+// arrays of rows and segmented data in the data field.
+func (r *queryResponse) UnmarshalJSON(b []byte) error {
+	type response queryResponse
+	v := struct {
+		*response
+		Data json.RawMessage `json:"data"`
+	}{response: (*response)(r)}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	data := bytes.TrimSpace(v.Data)
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if data[0] == '{' {
+		r.segments = &segmentedData{}
+		return json.Unmarshal(data, r.segments)
+	}
+	return decodeRows(data, &r.Data)
+}
+
+// decodeRows decodes a JSON array of rows, keeping numbers as json.Number.
+func decodeRows(b []byte, data *[]queryData) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	return d.Decode(data)
+}
+
+// loadSegments returns the rows of all segments of a page, in order.
+func (qr *driverRows) loadSegments(sd *segmentedData) ([]queryData, error) {
+	if sd.Encoding != jsonDataEncoding {
+		return nil, fmt.Errorf("presto: unsupported data encoding: %q", sd.Encoding)
+	}
+	pages := make([][]queryData, len(sd.Segments))
+	errs := make([]error, len(sd.Segments))
+	sem := make(chan struct{}, maxParallelSegments)
+	var wg sync.WaitGroup
+	for i := range sd.Segments {
+		seg := &sd.Segments[i]
+		switch seg.Type {
+		case "inline":
+			errs[i] = decodeRows(seg.Data, &pages[i])
+		case "spooled":
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int) {
+				defer wg.Done()
+				pages[i], errs[i] = qr.fetchSegment(seg)
+				<-sem
+			}(i)
+		default:
+			errs[i] = fmt.Errorf("presto: unsupported segment type: %q", seg.Type)
+		}
+	}
+	wg.Wait()
+	var rows []queryData
+	for i := range pages {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		rows = append(rows, pages[i]...)
+	}
+	return rows, nil
+}
+
+// fetchSegment downloads and decodes a spooled segment, and acknowledges it
+// so the server can release the storage.
+func (qr *driverRows) fetchSegment(seg *dataSegment) ([]queryData, error) {
+	req, err := http.NewRequestWithContext(qr.ctx, "GET", seg.URI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("presto: %v", err)
+	}
+	for k, vs := range seg.Headers {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	client, _ := qr.stmt.conn.clientFor(qr.ctx)
+	resp, err := qr.stmt.conn.do(&client, req, 1)
+	if err != nil {
+		return nil, &ErrQueryFailed{Reason: &ErrTransport{Err: err}}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newErrQueryFailedFromResponse(resp)
+	}
+	b, err := io.ReadAll(resp.Body)
+	atomic.AddInt64(&driverStats.bytesFetched, int64(len(b)))
+	if err != nil {
+		return nil, &ErrQueryFailed{StatusCode: resp.StatusCode, Reason: &ErrTransport{Err: err}}
+	}
+	var rows []queryData
+	if err = decodeRows(b, &rows); err != nil {
+		return nil, fmt.Errorf("presto: decoding spooled segment: %v", err)
+	}
+	if seg.AckURI != "" {
+		qr.ackSegment(seg.AckURI)
+	}
+	return rows, nil
+}
+
+// ackSegment acknowledges a segment on a best-effort basis; the server
+// eventually expires unacknowledged segments.
+func (qr *driverRows) ackSegment(uri string) {
+	req, err := qr.stmt.conn.newRequest("GET", uri, nil, nil)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(qr.ctx, DefaultCancelQueryTimeout)
+	defer cancel()
+	if err := qr.stmt.conn.authorize(ctx, req); err != nil {
+		return
+	}
+	client, _ := qr.stmt.conn.clientFor(ctx)
+	resp, err := qr.stmt.conn.do(&client, req.WithContext(ctx), 1)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,103 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSpooledSegments(t *testing.T) {
+	var acks int32
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/statement":
+			if v := r.Header.Get(prestoQueryDataEncodingHeader); v != "json" {
+				t.Errorf("unexpected data encoding header: %q", v)
+			}
+			json.NewEncoder(w).Encode(&stmtResponse{
+				ID:      "test_query",
+				NextURI: ts.URL + "/v1/statement/test_query/0",
+			})
+		case "/v1/statement/test_query/0":
+			fmt.Fprintf(w, `{
+				"id": "test_query",
+				"columns": [{"name": "x", "type": "bigint", "typeSignature": {"rawType": "bigint"}}],
+				"data": {"encoding": "json", "segments": [
+					{"type": "inline", "data": %q},
+					{"type": "spooled", "uri": "%s/segment/1", "ackUri": "%s/segment/1/ack", "headers": {"X-Segment-Token": ["secret"]}}
+				]},
+				"stats": {"state": "FINISHED"}
+			}`, base64.StdEncoding.EncodeToString([]byte("[[1],[2]]")), ts.URL, ts.URL)
+		case "/segment/1":
+			if v := r.Header.Get("X-Segment-Token"); v != "secret" {
+				t.Errorf("unexpected segment header: %q", v)
+			}
+			w.Write([]byte("[[3]]"))
+		case "/segment/1/ack":
+			atomic.AddInt32(&acks, 1)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL+"?query_data_encoding=json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []int64
+	for rows.Next() {
+		var x int64
+		if err = rows.Scan(&x); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, x)
+	}
+	var eof *EOF
+	if err = rows.Err(); !errors.As(err, &eof) {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "[1 2 3]" {
+		t.Fatalf("unexpected rows: %v", got)
+	}
+	if n := atomic.LoadInt32(&acks); n != 1 {
+		t.Fatalf("unexpected number of acks: %d", n)
+	}
+}
+
+func TestUnsupportedQueryDataEncoding(t *testing.T) {
+	db, err := sql.Open("presto", "http://localhost:9?query_data_encoding=arrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = db.Query("SELECT 1"); err == nil {
+		t.Fatal("unsupported encoding accepted")
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DriverStats is a snapshot of the driver-wide counters, shared by all
+// connections opened by this package.
+type DriverStats struct {
+	Requests     int64 // HTTP requests sent to presto
+	Retries      int64 // Requests retried after 503 Service Unavailable
+	RateLimited  int64 // Requests rejected with 429 Too Many Requests
+	Reexecutions int64 // Idempotent queries re-executed after failing mid-stream
+	Queries      int64 // Queries submitted
+	Failures     int64 // Queries that failed on submission or while fetching results
+	BytesFetched int64 // Bytes of response bodies read from presto
+	OpenCursors  int64 // Query results not closed yet
+	Failovers    int64 // Submissions retried on another coordinator after a gateway error
+}
+
+var driverStats struct {
+	requests     int64
+	retries      int64
+	rateLimited  int64
+	reexecutions int64
+	queries      int64
+	failures     int64
+	bytesFetched int64
+	openCursors  int64
+	failovers    int64
+}
+
+// Stats returns a snapshot of the driver statistics.
+func Stats() DriverStats {
+	return DriverStats{
+		Requests:     atomic.LoadInt64(&driverStats.requests),
+		Retries:      atomic.LoadInt64(&driverStats.retries),
+		RateLimited:  atomic.LoadInt64(&driverStats.rateLimited),
+		Reexecutions: atomic.LoadInt64(&driverStats.reexecutions),
+		Queries:      atomic.LoadInt64(&driverStats.queries),
+		Failures:     atomic.LoadInt64(&driverStats.failures),
+		BytesFetched: atomic.LoadInt64(&driverStats.bytesFetched),
+		OpenCursors:  atomic.LoadInt64(&driverStats.openCursors),
+		Failovers:    atomic.LoadInt64(&driverStats.failovers),
+	}
+}
+
+// QueryEnd describes a query that ended, for query hooks.
+type QueryEnd struct {
+	QueryID  string        // ID of the query, empty if it failed on submission
+	SQLHash  string        // Hash of the statement text, to group the runs of a statement without logging it
+	Duration time.Duration // Time from submission until the results were closed
+	Err      error         // Failure of the query, nil if it succeeded or was closed early
+}
+
+var queryHooks struct {
+	sync.RWMutex
+	hooks []func(QueryEnd)
+}
+
+// AddQueryHook registers a function called at the end of every query, when
+// its submission fails or its results are closed. Hooks are called
+// synchronously by the goroutine running the query, and must be safe for
+// concurrent use.
+func AddQueryHook(hook func(QueryEnd)) {
+	queryHooks.Lock()
+	defer queryHooks.Unlock()
+	queryHooks.hooks = append(queryHooks.hooks, hook)
+}
+
+// RequestEnd describes an HTTP request to presto that completed, for
+// request hooks.
+type RequestEnd struct {
+	RequestID  string        // ID sent in the X-Request-Id header
+	Method     string        // HTTP method
+	URL        string        // URL, with any password and query parameter values redacted
+	StatusCode int           // Status of the response, zero if none was received
+	Duration   time.Duration // Time until the response headers were received
+	Err        error         // Transport failure, nil if a response was received
+	RemoteAddr string        // Address of the host that served the request, e.g. a coordinator behind a gateway, empty if none was reached
+	Reused     bool          // Whether the request was sent on a connection kept alive from a previous request
+	Attempt    int           // Number of the request among the retries and redirects of the same request, starting at 1
+}
+
+var requestHooks struct {
+	sync.RWMutex
+	hooks []func(RequestEnd)
+}
+
+// AddRequestHook registers a function called after every HTTP request the
+// driver sends, from the submission of a query to the fetches of its
+// results and its cancellation. Like query hooks, they are called
+// synchronously and must be safe for concurrent use.
+func AddRequestHook(hook func(RequestEnd)) {
+	requestHooks.Lock()
+	defer requestHooks.Unlock()
+	requestHooks.hooks = append(requestHooks.hooks, hook)
+}
+
+func endRequest(e RequestEnd) {
+	requestHooks.RLock()
+	defer requestHooks.RUnlock()
+	for _, hook := range requestHooks.hooks {
+		hook(e)
+	}
+}
+
+// sqlHash returns the first 16 hexadecimal digits of the SHA-256 hash of a
+// statement.
+func sqlHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:8])
+}
+
+// QueryEvent is a query that ended, as kept by the event log.
+type QueryEvent struct {
+	Time     time.Time     // End of the query
+	QueryID  string        // ID of the query, empty if it failed on submission
+	SQLHash  string        // Hash of the statement text, as in QueryEnd
+	Duration time.Duration // Time from submission until the results were closed
+	Status   string        // "succeeded", "cancelled" or "failed"
+	Error    string        // Name of the failure of the query, empty if it succeeded
+}
+
+var eventLog struct {
+	sync.Mutex
+	events []QueryEvent // ring buffer of the last events, nil if disabled
+	next   int          // index of the next event in events
+	full   bool         // whether events wrapped around
+}
+
+// SetEventLogSize keeps the last n query events of the driver in memory, for
+// RecentQueries, e.g. to inspect the recent activity of an application from
+// a debug endpoint. Resizing drops the events kept so far, and n <= 0
+// disables the event log, which is the default.
+func SetEventLogSize(n int) {
+	eventLog.Lock()
+	defer eventLog.Unlock()
+	eventLog.events, eventLog.next, eventLog.full = nil, 0, false
+	if n > 0 {
+		eventLog.events = make([]QueryEvent, n)
+	}
+}
+
+// RecentQueries returns the events of the last queries kept by the event
+// log, oldest first, or nil if it is disabled.
+func RecentQueries() []QueryEvent {
+	eventLog.Lock()
+	defer eventLog.Unlock()
+	if !eventLog.full {
+		return append([]QueryEvent(nil), eventLog.events[:eventLog.next]...)
+	}
+	res := make([]QueryEvent, 0, len(eventLog.events))
+	res = append(res, eventLog.events[eventLog.next:]...)
+	return append(res, eventLog.events[:eventLog.next]...)
+}
+
+func logQuery(e QueryEnd) {
+	eventLog.Lock()
+	defer eventLog.Unlock()
+	if eventLog.events == nil {
+		return
+	}
+	ev := QueryEvent{
+		Time:     time.Now(),
+		QueryID:  e.QueryID,
+		SQLHash:  e.SQLHash,
+		Duration: e.Duration,
+		Status:   "succeeded",
+	}
+	if e.Err != nil {
+		ev.Status, ev.Error = "failed", failureName(e.Err)
+		if errors.Is(e.Err, context.Canceled) || errors.Is(e.Err, ErrQueryCancelled) {
+			ev.Status = "cancelled"
+		}
+	}
+	eventLog.events[eventLog.next] = ev
+	eventLog.next++
+	if eventLog.next == len(eventLog.events) {
+		eventLog.next, eventLog.full = 0, true
+	}
+}
+
+// failureName returns the name of a query failure kept by the event log:
+// the error name reported by presto, such as SYNTAX_ERROR, the HTTP status
+// of other failed responses, or the Go type of the error, past fmt.Errorf
+// wrapping. Error messages aren't kept, since they may quote literals of
+// the statement.
+func failureName(err error) string {
+	var se *stmtError
+	if errors.As(err, &se) && se.ErrorName != "" {
+		return se.ErrorName
+	}
+	var qf *ErrQueryFailed
+	if errors.As(err, &qf) && qf.StatusCode != 0 {
+		return fmt.Sprintf("HTTP %d", qf.StatusCode)
+	}
+	for {
+		t := reflect.TypeOf(err)
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.PkgPath() != "fmt" || errors.Unwrap(err) == nil {
+			return fmt.Sprintf("%T", err)
+		}
+		err = errors.Unwrap(err)
+	}
+}
+
+func endQuery(e QueryEnd) {
+	if e.Err != nil {
+		atomic.AddInt64(&driverStats.failures, 1)
+	}
+	logQuery(e)
+	queryHooks.RLock()
+	defer queryHooks.RUnlock()
+	for _, hook := range queryHooks.hooks {
+		hook(e)
+	}
+}
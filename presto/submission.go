@@ -0,0 +1,98 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"time"
+)
+
+// submissionMarker precedes the token identifying the submissions of a
+// query, in a comment appended to the statement. The comment starts on a
+// new line so that it does not shift the error locations of the first one.
+const submissionMarker = "presto_go_submission:"
+
+// submissionComment returns the comment appended to the statement of a
+// query to identify its submissions by token, empty if token is.
+func submissionComment(token string) string {
+	if token == "" {
+		return ""
+	}
+	return "\n/* " + submissionMarker + token + " */"
+}
+
+// isAmbiguousSubmission reports whether a submission failed without an
+// answer from presto, e.g. on a timeout, so the query may have been created.
+func isAmbiguousSubmission(ctx context.Context, err error) bool {
+	var qf *ErrQueryFailed
+	return ctx.Err() == nil && errors.As(err, &qf) && qf.StatusCode == 0 && qf.Reason != nil
+}
+
+// Lookups of the query of an ambiguous submission, which presto may not
+// list in system.runtime.queries right away, and the wait before the first
+// repeated one, doubled on each.
+const (
+	submissionLookups       = 3
+	submissionLookupBackoff = 50 * time.Millisecond
+)
+
+// checkSubmission looks for a query created by a submission that failed
+// with cause, on every coordinator, a few times. It returns nil if there is
+// none, so the query can be resubmitted.
+func (c *Conn) checkSubmission(ctx context.Context, token string, cause error) error {
+	// The marker and the token are concatenated by presto, so that the
+	// lookup does not find itself.
+	query := "SELECT query_id FROM system.runtime.queries WHERE strpos(query, '" +
+		submissionMarker + "' || '" + token + "') > 0"
+	for lookup := 0; lookup < submissionLookups; lookup++ {
+		if lookup > 0 {
+			if err := sleepBackoff(ctx, submissionLookupBackoff, time.Second, lookup-1); err != nil {
+				return &ErrAmbiguousSubmission{Reason: cause}
+			}
+		}
+		for _, baseURL := range c.coordinators {
+			id, err := c.findQuery(context.WithValue(ctx, coordinatorKey, baseURL), query)
+			if err != nil || id != "" {
+				return &ErrAmbiguousSubmission{QueryID: id, Reason: cause}
+			}
+		}
+	}
+	return nil
+}
+
+// findQuery runs a lookup query returning query IDs, and returns the first
+// one if any.
+func (c *Conn) findQuery(ctx context.Context, query string) (string, error) {
+	st := &driverStmt{conn: c, query: query}
+	rows, err := st.submit(ctx, query, nil)
+	if err != nil {
+		return "", err
+	}
+	defer rows.cancel()
+	dest := make([]driver.Value, 1)
+	err = rows.Next(dest)
+	var eof *EOF
+	if err == io.EOF || errors.As(err, &eof) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	id, _ := dest[0].(string)
+	return id, nil
+}
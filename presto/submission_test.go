@@ -0,0 +1,134 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSubmissionRetries(t *testing.T) {
+	columns := []queryColumn{{Name: "x", Type: "varchar", TypeSignature: typeSignature{RawType: "varchar"}}}
+	var mu sync.Mutex
+	var statements, lookups []string
+	created := 0 // lookup from which the query is listed, 0 if never
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPost:
+			b, _ := io.ReadAll(r.Body)
+			kind := "statement"
+			if strings.Contains(string(b), "system.runtime.queries") {
+				kind = "lookup"
+				lookups = append(lookups, string(b))
+			} else {
+				statements = append(statements, string(b))
+				if len(statements) == 1 {
+					// The submission fails without an answer.
+					conn, _, _ := w.(http.Hijacker).Hijack()
+					conn.Close()
+					return
+				}
+			}
+			json.NewEncoder(w).Encode(&stmtResponse{
+				ID:      kind,
+				NextURI: ts.URL + "/v1/statement/" + kind + "/0",
+			})
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			resp := queryResponse{ID: "test_query", Columns: columns}
+			if strings.Contains(r.URL.Path, "statement/0") || created > 0 && len(lookups) >= created {
+				resp.Data = []queryData{{"test_query"}}
+			}
+			json.NewEncoder(w).Encode(&resp)
+		}
+	}))
+	defer ts.Close()
+
+	query := func(dsn string, createdAt int) error {
+		mu.Lock()
+		statements, lookups, created = nil, nil, createdAt
+		mu.Unlock()
+		db, err := sql.Open("presto", ts.URL+dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		rows, err := db.Query("SELECT 1")
+		if err == nil {
+			err = rows.Close()
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return err
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		err := query("", 0)
+		var qf *ErrQueryFailed
+		if !errors.As(err, &qf) || len(statements) != 1 || len(lookups) != 0 {
+			t.Fatalf("unexpected result after %d submissions: %v", len(statements), err)
+		}
+	})
+
+	t.Run("resubmitted", func(t *testing.T) {
+		if err := query("?submission_retries=1", 0); err != nil {
+			t.Fatal(err)
+		}
+		if len(statements) != 2 || len(lookups) != submissionLookups {
+			t.Fatalf("want 2 submissions and %d lookups, got %d and %d", submissionLookups, len(statements), len(lookups))
+		}
+		if statements[0] != statements[1] || !strings.HasPrefix(statements[0], "SELECT 1\n/* "+submissionMarker) {
+			t.Fatalf("want identical tagged statements, got %q", statements)
+		}
+		token := strings.TrimSuffix(strings.TrimPrefix(statements[0], "SELECT 1\n/* "), " */")
+		if strings.Contains(lookups[0], token) || !strings.Contains(lookups[0], strings.TrimPrefix(token, submissionMarker)) {
+			t.Fatalf("lookup does not match only the statement: %q", lookups[0])
+		}
+	})
+
+	t.Run("created", func(t *testing.T) {
+		err := query("?submission_retries=1", 1)
+		var as *ErrAmbiguousSubmission
+		if !errors.As(err, &as) || as.QueryID != "test_query" {
+			t.Fatal("unexpected error:", err)
+		}
+		if len(statements) != 1 || len(lookups) != 1 {
+			t.Fatalf("want 1 submission and 1 lookup, got %d and %d", len(statements), len(lookups))
+		}
+	})
+
+	t.Run("listed late", func(t *testing.T) {
+		// The query is not listed by the first lookup yet.
+		err := query("?submission_retries=1", 2)
+		var as *ErrAmbiguousSubmission
+		if !errors.As(err, &as) || as.QueryID != "test_query" {
+			t.Fatal("unexpected error:", err)
+		}
+		if len(statements) != 1 || len(lookups) != 2 {
+			t.Fatalf("want 1 submission and 2 lookups, got %d and %d", len(statements), len(lookups))
+		}
+	})
+}
@@ -0,0 +1,344 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Identifier is a possibly qualified SQL identifier, such as a column name
+// or a catalog.schema.table name, given as its unquoted parts.
+type Identifier []string
+
+// String returns the identifier with each part quoted.
+func (id Identifier) String() string {
+	parts := make([]string, len(id))
+	for i, p := range id {
+		parts[i] = QuoteIdentifier(p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// QuoteIdentifier quotes a single identifier part, escaping double quotes.
+func QuoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// Interpolate substitutes the {name} placeholders of a query skeleton with
+// the named arguments, for queries that can't use placeholders because they
+// vary in identifiers, such as dynamic pivots or partition filters.
+//
+// Identifier arguments are quoted as identifiers and []Identifier arguments
+// as comma separated lists of identifiers; any other argument is serialized
+// as a literal by Serial. Braces inside quoted strings, identifiers and
+// comments of the skeleton are left untouched.
+//
+//	q, err := presto.Interpolate(
+//		"SELECT {cols} FROM {table} WHERE ds = {ds}",
+//		sql.Named("cols", []presto.Identifier{{"a"}, {"b"}}),
+//		sql.Named("table", presto.Identifier{"hive", "web", "events"}),
+//		sql.Named("ds", "2017-07-10"),
+//	)
+func Interpolate(skeleton string, args ...sql.NamedArg) (string, error) {
+	values := make(map[string]interface{}, len(args))
+	for _, arg := range args {
+		values[arg.Name] = arg.Value
+	}
+	var b strings.Builder
+	for i := 0; i < len(skeleton); i++ {
+		end, err := skipLiteral(skeleton, i)
+		if err != nil {
+			return "", err
+		}
+		if end > i {
+			b.WriteString(skeleton[i:end])
+			i = end - 1
+			continue
+		}
+		switch c := skeleton[i]; c {
+		case '{':
+			end := strings.IndexByte(skeleton[i+1:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("presto: unterminated placeholder at offset %d", i)
+			}
+			name := skeleton[i+1 : i+end+1]
+			v, ok := values[name]
+			if !ok {
+				return "", fmt.Errorf("presto: missing template argument %q", name)
+			}
+			s, err := interpolateValue(v)
+			if err != nil {
+				return "", fmt.Errorf("presto: template argument %q: %v", name, err)
+			}
+			b.WriteString(s)
+			i += end + 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// quoteEnd returns the offset of the quote closing the string or identifier
+// starting at offset i of the query. Escaped quotes are handled as adjacent
+// quoted sections.
+func quoteEnd(query string, i int) (int, error) {
+	end := strings.IndexByte(query[i+1:], query[i])
+	if end < 0 {
+		return 0, fmt.Errorf("presto: unterminated quote at offset %d", i)
+	}
+	return i + end + 1, nil
+}
+
+func interpolateValue(v interface{}) (string, error) {
+	switch x := v.(type) {
+	case Identifier:
+		if err := validIdentifier(x); err != nil {
+			return "", err
+		}
+		return x.String(), nil
+	case []Identifier:
+		if len(x) == 0 {
+			return "", fmt.Errorf("empty identifier list")
+		}
+		ss := make([]string, len(x))
+		for i, id := range x {
+			if err := validIdentifier(id); err != nil {
+				return "", err
+			}
+			ss[i] = id.String()
+		}
+		return strings.Join(ss, ", "), nil
+	}
+	return Serial(v)
+}
+
+func validIdentifier(id Identifier) error {
+	if len(id) == 0 {
+		return fmt.Errorf("empty identifier")
+	}
+	for _, p := range id {
+		if p == "" {
+			return fmt.Errorf("empty identifier part in %v", id)
+		}
+	}
+	return nil
+}
+
+// maxInListSize is the number of values above which ExpandIn uses a VALUES
+// subquery rather than a list of placeholders.
+const maxInListSize = 1000
+
+// ExpandIn expands the ? placeholders of a query whose argument is a slice,
+// other than []byte, into one placeholder per element, returning the new
+// query and the flattened arguments. Slices are meant for IN predicates
+// written as "x IN (?)": short ones become "x IN (?, ?, ?)", and ones longer
+// than 1000 elements become "x IN (SELECT v FROM (VALUES ?, ?, ...) AS t(v))",
+// which presto runs as a semi-join instead of a long chain of comparisons.
+//
+//	q, args, err := presto.ExpandIn("SELECT * FROM t WHERE id IN (?) AND ds = ?", ids, ds)
+//	rows, err := db.Query(q, args...)
+func ExpandIn(query string, args ...interface{}) (string, []interface{}, error) {
+	var b strings.Builder
+	var expanded []interface{}
+	n := 0
+	for i := 0; i < len(query); i++ {
+		end, err := skipLiteral(query, i)
+		if err != nil {
+			return "", nil, err
+		}
+		if end > i {
+			b.WriteString(query[i:end])
+			i = end - 1
+			continue
+		}
+		switch c := query[i]; c {
+		case '?':
+			if n >= len(args) {
+				return "", nil, fmt.Errorf("presto: missing argument for placeholder %d", n+1)
+			}
+			arg := args[n]
+			n++
+			v := reflect.ValueOf(arg)
+			if arg == nil || v.Kind() != reflect.Slice || v.Type().Elem().Kind() == reflect.Uint8 {
+				b.WriteByte('?')
+				expanded = append(expanded, arg)
+				continue
+			}
+			if v.Len() == 0 {
+				return "", nil, fmt.Errorf("presto: empty slice for placeholder %d", n)
+			}
+			placeholders := strings.Repeat(", ?", v.Len())[2:]
+			if v.Len() > maxInListSize {
+				placeholders = valuesSubquery(placeholders)
+			}
+			b.WriteString(placeholders)
+			for j := 0; j < v.Len(); j++ {
+				expanded = append(expanded, v.Index(j).Interface())
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if n != len(args) {
+		return "", nil, fmt.Errorf("presto: %d arguments for %d placeholders", len(args), n)
+	}
+	return b.String(), expanded, nil
+}
+
+// valuesSubquery returns the subquery selecting the comma separated values
+// of an IN list from a VALUES relation.
+func valuesSubquery(values string) string {
+	return "SELECT v FROM (VALUES " + values + ") AS t(v)"
+}
+
+// rewriteInLists rewrites the IN predicates of query with lists of more than
+// threshold values, literals or placeholders, as subqueries selecting them
+// from a VALUES relation, like ExpandIn. Lists of rows and subqueries are
+// left untouched, as is the query if it can't be scanned.
+func rewriteInLists(query string, threshold int) string {
+	var b strings.Builder
+	copied := 0
+	for i := 0; i < len(query); i++ {
+		end, err := skipLiteral(query, i)
+		if err != nil {
+			return query
+		}
+		if end > i {
+			i = end - 1
+			continue
+		}
+		if !isKeywordAt(query, i, "in") {
+			continue
+		}
+		open := i + 2
+		for open < len(query) && isSpace(query[open]) {
+			open++
+		}
+		if open >= len(query) || query[open] != '(' {
+			continue
+		}
+		values, closing, err := splitList(query, open)
+		if err != nil {
+			return query
+		}
+		if len(values) <= threshold || !isScalarList(values) {
+			continue
+		}
+		b.WriteString(query[copied : open+1])
+		b.WriteString(valuesSubquery(strings.Join(values, ", ")))
+		copied = closing
+		i = closing
+	}
+	if copied == 0 {
+		return query
+	}
+	b.WriteString(query[copied:])
+	return b.String()
+}
+
+// skipLiteral returns the offset following the quoted string or identifier,
+// or the comment, starting at offset i of the query, or i if there is none.
+func skipLiteral(query string, i int) (int, error) {
+	switch {
+	case query[i] == '\'' || query[i] == '"':
+		end, err := quoteEnd(query, i)
+		return end + 1, err
+	case strings.HasPrefix(query[i:], "--"):
+		end := strings.IndexByte(query[i:], '\n')
+		if end < 0 {
+			return len(query), nil
+		}
+		return i + end + 1, nil
+	case strings.HasPrefix(query[i:], "/*"):
+		end := strings.Index(query[i+2:], "*/")
+		if end < 0 {
+			return 0, fmt.Errorf("presto: unterminated comment at offset %d", i)
+		}
+		return i + 2 + end + 2, nil
+	}
+	return i, nil
+}
+
+// splitList returns the trimmed comma separated elements of the
+// parenthesized list starting at offset open of the query, and the offset
+// of its closing parenthesis.
+func splitList(query string, open int) ([]string, int, error) {
+	var elems []string
+	depth, start := 0, open+1
+	for i := open + 1; i < len(query); i++ {
+		end, err := skipLiteral(query, i)
+		if err != nil {
+			return nil, 0, err
+		}
+		if end > i {
+			i = end - 1
+			continue
+		}
+		switch query[i] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				elems = append(elems, strings.TrimSpace(query[start:i]))
+				return elems, i, nil
+			}
+			depth--
+		case ',':
+			if depth == 0 {
+				elems = append(elems, strings.TrimSpace(query[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return nil, 0, fmt.Errorf("presto: unterminated list at offset %d", open)
+}
+
+// isScalarList reports whether the elements of an IN list are single
+// values, rather than rows or a subquery.
+func isScalarList(elems []string) bool {
+	for _, e := range elems {
+		if e == "" || e[0] == '(' {
+			return false
+		}
+	}
+	for _, kw := range []string{"select", "with", "values", "table"} {
+		if isKeywordAt(elems[0], 0, kw) {
+			return false
+		}
+	}
+	return true
+}
+
+// isKeywordAt reports whether the keyword, in lower case, appears at offset
+// i of the query as a whole word, in any case.
+func isKeywordAt(query string, i int, keyword string) bool {
+	end := i + len(keyword)
+	if end > len(query) || !strings.EqualFold(query[i:end], keyword) {
+		return false
+	}
+	return (i == 0 || !isIdentifierChar(query[i-1])) && (end == len(query) || !isIdentifierChar(query[end]))
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -0,0 +1,276 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	scenarios := []struct {
+		name          string
+		skeleton      string
+		args          []sql.NamedArg
+		expectedError bool
+		expected      string
+	}{
+		{
+			name:     "identifiers and literals",
+			skeleton: "SELECT {cols} FROM {table} WHERE ds = {ds} AND n > {n}",
+			args: []sql.NamedArg{
+				sql.Named("cols", []Identifier{{"a"}, {`we"ird`}}),
+				sql.Named("table", Identifier{"hive", "web", "events"}),
+				sql.Named("ds", "2017-07-10'"),
+				sql.Named("n", 5),
+			},
+			expected: `SELECT "a", "we""ird" FROM "hive"."web"."events" WHERE ds = '2017-07-10''' AND n > 5`,
+		},
+		{
+			name:     "braces in quotes",
+			skeleton: `SELECT regexp_like(s, 'a{2}'), "{x}" FROM {t}`,
+			args:     []sql.NamedArg{sql.Named("t", Identifier{"t"})},
+			expected: `SELECT regexp_like(s, 'a{2}'), "{x}" FROM "t"`,
+		},
+		{
+			name:     "comments",
+			skeleton: "SELECT {c} -- don't use {x}\nFROM /* it's {y} */ {t}",
+			args:     []sql.NamedArg{sql.Named("c", Identifier{"c"}), sql.Named("t", Identifier{"t"})},
+			expected: "SELECT \"c\" -- don't use {x}\nFROM /* it's {y} */ \"t\"",
+		},
+		{
+			name:          "unterminated comment",
+			skeleton:      "SELECT {c} /* it's",
+			args:          []sql.NamedArg{sql.Named("c", Identifier{"c"})},
+			expectedError: true,
+		},
+		{
+			name:          "missing argument",
+			skeleton:      "SELECT * FROM {t}",
+			expectedError: true,
+		},
+		{
+			name:          "empty identifier",
+			skeleton:      "SELECT * FROM {t}",
+			args:          []sql.NamedArg{sql.Named("t", Identifier{"hive", ""})},
+			expectedError: true,
+		},
+		{
+			name:          "unsupported literal",
+			skeleton:      "SELECT {x}",
+			args:          []sql.NamedArg{sql.Named("x", 1.5)},
+			expectedError: true,
+		},
+		{
+			name:          "unterminated placeholder",
+			skeleton:      "SELECT {x",
+			args:          []sql.NamedArg{sql.Named("x", 1)},
+			expectedError: true,
+		},
+		{
+			name:          "unterminated quote",
+			skeleton:      "SELECT 'x",
+			expectedError: true,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			s, err := Interpolate(scenario.skeleton, scenario.args...)
+			if err != nil {
+				if scenario.expectedError {
+					return
+				}
+				t.Fatal(err)
+			}
+			if scenario.expectedError {
+				t.Fatalf("expected an error, got %q", s)
+			}
+			if s != scenario.expected {
+				t.Fatalf("unexpected query:\nhave %s\nwant %s", s, scenario.expected)
+			}
+		})
+	}
+}
+
+func TestExpandIn(t *testing.T) {
+	large := make([]int, maxInListSize+1)
+	largePlaceholders := "?" + strings.Repeat(", ?", maxInListSize)
+
+	scenarios := []struct {
+		name          string
+		query         string
+		args          []interface{}
+		expectedError bool
+		expected      string
+		expectedArgs  int
+	}{
+		{
+			name:         "short slice",
+			query:        "SELECT * FROM t WHERE id IN (?) AND ds = ?",
+			args:         []interface{}{[]string{"a", "b", "c"}, "2017-07-10"},
+			expected:     "SELECT * FROM t WHERE id IN (?, ?, ?) AND ds = ?",
+			expectedArgs: 4,
+		},
+		{
+			name:         "large slice",
+			query:        "SELECT * FROM t WHERE id IN (?)",
+			args:         []interface{}{large},
+			expected:     "SELECT * FROM t WHERE id IN (SELECT v FROM (VALUES " + largePlaceholders + ") AS t(v))",
+			expectedArgs: maxInListSize + 1,
+		},
+		{
+			name:         "placeholder in quotes",
+			query:        "SELECT '?' FROM t WHERE id IN (?)",
+			args:         []interface{}{[]int{1, 2}},
+			expected:     "SELECT '?' FROM t WHERE id IN (?, ?)",
+			expectedArgs: 2,
+		},
+		{
+			name:         "placeholder in comments",
+			query:        "SELECT * FROM t -- don't use ?\nWHERE /* it's ? */ id IN (?)",
+			args:         []interface{}{[]int{1, 2}},
+			expected:     "SELECT * FROM t -- don't use ?\nWHERE /* it's ? */ id IN (?, ?)",
+			expectedArgs: 2,
+		},
+		{
+			name:          "empty slice",
+			query:         "SELECT * FROM t WHERE id IN (?)",
+			args:          []interface{}{[]int{}},
+			expectedError: true,
+		},
+		{
+			name:          "argument count mismatch",
+			query:         "SELECT * FROM t WHERE id IN (?)",
+			args:          []interface{}{[]int{1}, 2},
+			expectedError: true,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			q, args, err := ExpandIn(scenario.query, scenario.args...)
+			if err != nil {
+				if scenario.expectedError {
+					return
+				}
+				t.Fatal(err)
+			}
+			if scenario.expectedError {
+				t.Fatalf("expected an error, got %q", q)
+			}
+			if q != scenario.expected {
+				t.Fatalf("unexpected query:\nhave %s\nwant %s", q, scenario.expected)
+			}
+			if len(args) != scenario.expectedArgs {
+				t.Fatalf("unexpected number of arguments: %d", len(args))
+			}
+		})
+	}
+}
+
+func TestRewriteInLists(t *testing.T) {
+	scenarios := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{
+			name:     "long list",
+			query:    "SELECT * FROM t WHERE id IN (1, 2, 3) AND ds = '2017-07-10'",
+			expected: "SELECT * FROM t WHERE id IN (SELECT v FROM (VALUES 1, 2, 3) AS t(v)) AND ds = '2017-07-10'",
+		},
+		{
+			name:     "short list",
+			query:    "SELECT * FROM t WHERE id IN (1, 2)",
+			expected: "SELECT * FROM t WHERE id IN (1, 2)",
+		},
+		{
+			name:     "placeholders and nested calls",
+			query:    "SELECT * FROM t WHERE name in\n(?, lower(?), concat(?, 'a,b'))",
+			expected: "SELECT * FROM t WHERE name in\n(SELECT v FROM (VALUES ?, lower(?), concat(?, 'a,b')) AS t(v))",
+		},
+		{
+			name:     "several lists",
+			query:    "SELECT * FROM t WHERE a IN (1, 2, 3) OR b NOT IN ('x', 'y', 'z')",
+			expected: "SELECT * FROM t WHERE a IN (SELECT v FROM (VALUES 1, 2, 3) AS t(v)) OR b NOT IN (SELECT v FROM (VALUES 'x', 'y', 'z') AS t(v))",
+		},
+		{
+			name:     "subquery",
+			query:    "SELECT * FROM t WHERE id IN (SELECT max(id) FROM u GROUP BY a, b, c)",
+			expected: "SELECT * FROM t WHERE id IN (SELECT max(id) FROM u GROUP BY a, b, c)",
+		},
+		{
+			name:     "rows",
+			query:    "SELECT * FROM t WHERE (a, b) IN ((1, 2), (3, 4), (5, 6))",
+			expected: "SELECT * FROM t WHERE (a, b) IN ((1, 2), (3, 4), (5, 6))",
+		},
+		{
+			name:     "quotes and comments",
+			query:    "SELECT 'in (1, 2, 3)', \"in\" FROM t -- IN (1, 2, 3)\nWHERE /* in (1, 2, 3) */ x = 1",
+			expected: "SELECT 'in (1, 2, 3)', \"in\" FROM t -- IN (1, 2, 3)\nWHERE /* in (1, 2, 3) */ x = 1",
+		},
+		{
+			name:     "keyword inside identifier",
+			query:    "SELECT * FROM t JOIN u ON t.id = u.id WHERE login(1, 2, 3)",
+			expected: "SELECT * FROM t JOIN u ON t.id = u.id WHERE login(1, 2, 3)",
+		},
+		{
+			name:     "unterminated list",
+			query:    "SELECT * FROM t WHERE id IN (1, 2, 3",
+			expected: "SELECT * FROM t WHERE id IN (1, 2, 3",
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.name, func(t *testing.T) {
+			if q := rewriteInLists(scenario.query, 2); q != scenario.expected {
+				t.Fatalf("unexpected query:\nhave %s\nwant %s", q, scenario.expected)
+			}
+		})
+	}
+}
+
+func TestInListThreshold(t *testing.T) {
+	ts := newPagedServer(queryResponse{})
+	var statements []string
+	ts.Config.Handler = func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				b, _ := io.ReadAll(r.Body)
+				statements = append(statements, string(b))
+			}
+			h.ServeHTTP(w, r)
+		})
+	}(ts.Config.Handler)
+	defer ts.Close()
+	db, err := sql.Open("presto", ts.URL+"?in_list_threshold=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT * FROM t WHERE id IN (1, 2, 3)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	want := "SELECT * FROM t WHERE id IN (SELECT v FROM (VALUES 1, 2, 3) AS t(v))"
+	if len(statements) != 1 || statements[0] != want {
+		t.Fatalf("want statement %q, got %q", want, statements)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClientCertificates(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "tenant-a"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	clientCA := x509.NewCertPool()
+	clientCA.AppendCertsFromPEM(certPEM)
+
+	var peers []string
+	var ts *httptest.Server
+	ts = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			json.NewEncoder(w).Encode(&queryResponse{ID: "test_query", Stats: stmtStats{State: "FINISHED"}})
+			return
+		}
+		peers = append(peers, r.TLS.PeerCertificates[0].Subject.CommonName)
+		json.NewEncoder(w).Encode(&stmtResponse{ID: "test_query", NextURI: ts.URL + "/v1/statement/test_query/0"})
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCA}
+	ts.StartTLS()
+	defer ts.Close()
+
+	dir := t.TempDir()
+	caPath := filepath.Join(dir, "ca.pem")
+	certPath := filepath.Join(dir, "client.pem")
+	keyPath := filepath.Join(dir, "client-key.pem")
+	for path, b := range map[string][]byte{
+		caPath:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}),
+		certPath: certPEM,
+		keyPath:  keyPEM,
+	} {
+		if err := os.WriteFile(path, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query := func(db *sql.DB) error {
+		defer db.Close()
+		rows, err := db.Query("SELECT 1")
+		if err != nil {
+			return err
+		}
+		return rows.Close()
+	}
+	dsn, err := (&Config{
+		PrestoURI:         ts.URL,
+		SSLCertPath:       caPath,
+		SSLClientCertPath: certPath,
+		SSLClientKeyPath:  keyPath,
+	}).FormatDSN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("presto", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := query(db); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = sql.Open("presto", ts.URL+"?SSLCertPath="+url.QueryEscape(caPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := query(db); err == nil {
+		t.Fatal("want handshake failure without client certificate")
+	}
+
+	db, err = sql.Open("presto", ts.URL+"?SSLClientKeyPath="+url.QueryEscape(keyPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dsnErr *ErrInvalidDSN
+	if err := query(db); !errors.As(err, &dsnErr) || dsnErr.Param != "SSLClientCertPath" {
+		t.Fatal("want invalid dsn error for missing certificate, got", err)
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCA := x509.NewCertPool()
+	serverCA.AddCert(ts.Certificate())
+	connector, err := NewConnector(&Config{
+		PrestoURI: ts.URL,
+		TLSConfig: &tls.Config{RootCAs: serverCA, Certificates: []tls.Certificate{cert}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := query(sql.OpenDB(connector)); err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 2 || peers[0] != "tenant-a" || peers[1] != "tenant-a" {
+		t.Fatalf("want two queries authenticated as tenant-a, got %q", peers)
+	}
+
+	if _, err := (&Config{PrestoURI: "http://localhost:8080", SSLClientCertPath: certPath, SSLClientKeyPath: keyPath}).FormatDSN(); err == nil {
+		t.Fatal("want configuration error for client certificates over http")
+	}
+}
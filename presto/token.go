@@ -0,0 +1,36 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+)
+
+// TokenProvider provides the access tokens sent as bearer tokens, asked for
+// one before every HTTP request so that short-lived tokens, e.g. of OAuth2
+// or OIDC, are refreshed without reopening the database. Token is called
+// with the context of the query; implementations should cache tokens until
+// they expire, and must be safe for concurrent use.
+type TokenProvider interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenProviderFunc adapts a function to a TokenProvider.
+type TokenProviderFunc func(ctx context.Context) (string, error)
+
+// Token implements the TokenProvider interface.
+func (f TokenProviderFunc) Token(ctx context.Context) (string, error) {
+	return f(ctx)
+}
@@ -0,0 +1,76 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestTokenProvider(t *testing.T) {
+	ts := newPagedServer(queryResponse{})
+	var tokens []string
+	ts.Config.Handler = func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokens = append(tokens, r.Header.Get("Authorization"))
+			h.ServeHTTP(w, r)
+		})
+	}(ts.Config.Handler)
+	defer ts.Close()
+
+	type key struct{}
+	var issued int
+	var fail error
+	connector, err := NewConnector(&Config{
+		PrestoURI:   ts.URL,
+		AccessToken: "stale",
+		TokenProvider: TokenProviderFunc(func(ctx context.Context) (string, error) {
+			if fail != nil {
+				return "", fail
+			}
+			if ctx.Value(key{}) != "query" {
+				t.Error("token provider not called with the query context")
+			}
+			issued++
+			return "token" + strconv.Itoa(issued), nil
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	ctx := context.WithValue(context.Background(), key{}, "query")
+	rows, err := db.QueryContext(ctx, "SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	if len(tokens) != 2 || tokens[0] != "Bearer token1" || tokens[1] != "Bearer token2" {
+		t.Fatalf("want a fresh token for submission and fetch, got %q", tokens)
+	}
+
+	fail = errors.New("expired refresh token")
+	if _, err := db.QueryContext(ctx, "SELECT 1"); !errors.Is(err, fail) {
+		t.Fatal("want token provider error, got", err)
+	}
+	if len(tokens) != 2 {
+		t.Fatal("request sent without a token")
+	}
+}
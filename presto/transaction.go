@@ -18,7 +18,7 @@ func (t *driverTx) Commit() error {
 
 	ctx := context.Background()
 	stmt := &driverStmt{conn: t.conn, query: "COMMIT"}
-	_, err := stmt.QueryContext(ctx, []driver.NamedValue{})
+	err := stmt.exec(ctx)
 	if err != nil {
 		return err
 	}
@@ -34,7 +34,7 @@ func (t *driverTx) Rollback() error {
 
 	ctx := context.Background()
 	stmt := &driverStmt{conn: t.conn, query: "ROLLBACK"}
-	_, err := stmt.QueryContext(ctx, []driver.NamedValue{})
+	err := stmt.exec(ctx)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,54 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	prestoHeaderPrefix = "X-Presto-"
+	trinoHeaderPrefix  = "X-Trino-"
+)
+
+var registerTrino sync.Once
+
+// RegisterTrino registers the driver under the name "trino" too, for
+// servers that speak the Trino dialect of the protocol. Its connections
+// take the same DSN as the presto driver, and send and receive the protocol
+// headers with the X-Trino- prefix instead of X-Presto-. The temporal
+// values of Trino, with a variable precision, are decoded like the ones of
+// presto. It is safe to call more than once.
+func RegisterTrino() {
+	registerTrino.Do(func() {
+		sql.Register("trino", &sqldriver{trino: true})
+	})
+}
+
+// renameHeaders returns a copy of hs where the keys starting with from
+// start with to instead.
+func renameHeaders(hs http.Header, from, to string) http.Header {
+	res := make(http.Header, len(hs))
+	for k, v := range hs {
+		if strings.HasPrefix(k, from) {
+			k = to + strings.TrimPrefix(k, from)
+		}
+		res[k] = v
+	}
+	return res
+}
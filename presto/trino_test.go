@@ -0,0 +1,74 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTrinoDriver(t *testing.T) {
+	RegisterTrino()
+	RegisterTrino()
+
+	var headers []http.Header
+	ts := newPagedServer(queryResponse{})
+	ts.Config.Handler = func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				headers = append(headers, r.Header.Clone())
+				w.Header().Set("X-Trino-Started-Transaction-Id", "tx1")
+			}
+			h.ServeHTTP(w, r)
+		})
+	}(ts.Config.Handler)
+	defer ts.Close()
+
+	db, err := sql.Open("trino", ts.URL+"?user=alice&catalog=hive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; i < 2; i++ {
+		rows, err := conn.QueryContext(context.Background(), "SELECT 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+	if len(headers) != 2 {
+		t.Fatalf("want 2 queries, got %d", len(headers))
+	}
+	h := headers[0]
+	if h.Get("X-Trino-User") != "alice" || h.Get("X-Trino-Catalog") != "hive" {
+		t.Errorf("want trino headers, got %v", h)
+	}
+	for k := range h {
+		if strings.HasPrefix(k, prestoHeaderPrefix) {
+			t.Errorf("unexpected presto header %s", k)
+		}
+	}
+	if id := headers[1].Get("X-Trino-Transaction-Id"); id != "tx1" {
+		t.Errorf("want transaction from the trino response header, got %q", id)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// WarmUp establishes n HTTP connections to every coordinator of the
+// connector, and gets a first token from its TokenProvider if any, so that
+// the first queries don't pay for the TCP, TLS and authentication
+// handshakes, e.g. at the startup of a service:
+//
+//	connector, _ := presto.NewConnector(config)
+//	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+//	defer cancel()
+//	err := presto.WarmUp(ctx, connector, 4)
+//	db := sql.OpenDB(connector)
+//
+// It sends concurrent requests for the server information of the
+// coordinators, and fails if any of them fails or ctx ends first. The HTTP
+// connections are kept idle in the pool of the transport of the connector,
+// which keeps at most MaxIdleConnsPerHost of them per coordinator: 2 with
+// the default transport.
+func WarmUp(ctx context.Context, c driver.Connector, n int) error {
+	pc, ok := c.(*connector)
+	if !ok {
+		return fmt.Errorf("presto: cannot warm up %T, not a presto connector", c)
+	}
+	dc, err := pc.Connect(ctx)
+	if err != nil {
+		return err
+	}
+	conn := dc.(*Conn)
+	defer conn.Close()
+	if conn.tokenProvider != nil {
+		if _, err := conn.tokenProvider.Token(ctx); err != nil {
+			return fmt.Errorf("presto: getting access token: %w", err)
+		}
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, n*len(conn.coordinators))
+	for _, baseURL := range conn.coordinators {
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(baseURL string) {
+				defer wg.Done()
+				errs <- conn.warmUp(ctx, baseURL)
+			}(baseURL)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// warmUp sends a request for the server information of a coordinator,
+// whose connection returns to the pool once its response is read. Any
+// response will do, even an authentication failure.
+func (c *Conn) warmUp(ctx context.Context, baseURL string) error {
+	req, err := c.newRequest("GET", baseURL+"/v1/info", nil, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if err := c.authorize(ctx, req); err != nil {
+		return err
+	}
+	client, _ := c.clientFor(ctx)
+	resp, err := c.do(&client, req, 1)
+	if err != nil {
+		return fmt.Errorf("presto: warming up %s: %w", baseURL, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
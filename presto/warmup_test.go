@@ -0,0 +1,98 @@
+// Copyright (c) Facebook, Inc. and its affiliates. All Rights Reserved
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package presto
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWarmUp(t *testing.T) {
+	var mu sync.Mutex
+	var infos int
+	var tokens []string
+	clients := make(map[string]bool)
+	dials := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(clients)
+	}
+	ts := newPagedServer(queryResponse{})
+	ts.Config.Handler = func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			// Every connection has its own client address.
+			clients[r.RemoteAddr] = true
+			tokens = append(tokens, r.Header.Get("Authorization"))
+			if r.URL.Path == "/v1/info" {
+				infos++
+			}
+			mu.Unlock()
+			if r.URL.Path == "/v1/info" {
+				w.Write([]byte(`{"starting":false}`))
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}(ts.Config.Handler)
+	defer ts.Close()
+
+	var issued int64
+	connector, err := NewConnector(&Config{
+		PrestoURI: ts.URL,
+		TokenProvider: TokenProviderFunc(func(ctx context.Context) (string, error) {
+			atomic.AddInt64(&issued, 1)
+			return "token", nil
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WarmUp(context.Background(), connector, 2); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if len(clients) != 2 || infos != 2 || atomic.LoadInt64(&issued) != 3 {
+		t.Fatalf("want 2 connections for 2 requests and 3 tokens, got %d connections for %d requests and %d tokens", len(clients), infos, issued)
+	}
+	for _, token := range tokens {
+		if token != "Bearer token" {
+			t.Fatalf("want authorized warm-up requests, got %q", tokens)
+		}
+	}
+	mu.Unlock()
+
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	rows, err := db.Query("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	if n := dials(); n != 2 {
+		t.Fatalf("want the query sent on a warm connection, got %d connections", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := WarmUp(ctx, connector, 1); !errors.Is(err, context.Canceled) {
+		t.Fatal("want context error, got", err)
+	}
+}